@@ -0,0 +1,338 @@
+package ens
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+const (
+	// acmeChallengeLabel is the label prefixed to a name to request its ACME
+	// DNS-01 challenges.
+	acmeChallengeLabel = "_acme-challenge"
+	// acmeChallengeTTL is the TTL of challenge records, which change often.
+	acmeChallengeTTL = 60
+	// acmeChallengeLifetime is the time after which challenges presented
+	// through the API are removed if they have not been cleaned up.
+	acmeChallengeLifetime = time.Hour
+)
+
+// acmeChallenge is a challenge presented through the API.
+type acmeChallenge struct {
+	value   string
+	expires time.Time
+}
+
+// challengeStore holds the ACME DNS-01 challenges for the zones of a server
+// block, read from a file or presented through an HTTP API, and answers TXT
+// requests for them.
+type challengeStore struct {
+	file     string
+	zones    []string
+	mappings []suffixMapping
+	// api is the API through which challenges are presented, if any.
+	api *challengeAPI
+
+	mu       sync.Mutex
+	modTime  time.Time
+	fromFile map[string][]string
+}
+
+func newChallengeStore(file string, zones []string, mappings []suffixMapping, api *challengeAPI) *challengeStore {
+	return &challengeStore{
+		file:     file,
+		zones:    zones,
+		mappings: mappings,
+		api:      api,
+		fromFile: make(map[string][]string),
+	}
+}
+
+// challengeName returns the DNS name under which the challenges for a name
+// are served, or false if the name is not a challenge name within the zones
+// of the store.  Names under an ENS suffix are mapped to their DNS suffix.
+func (s *challengeStore) challengeName(name string) (string, bool) {
+	name = dns.Fqdn(strings.ToLower(name))
+	if !strings.HasPrefix(name, acmeChallengeLabel+".") {
+		return "", false
+	}
+	if plugin.Zones(s.zones).Matches(name) != "" {
+		return name, true
+	}
+	for i := range s.mappings {
+		if dnsName := s.mappings[i].toDNS(name); dnsName != name && plugin.Zones(s.zones).Matches(dnsName) != "" {
+			return dnsName, true
+		}
+	}
+	return "", false
+}
+
+// lookup returns the challenges for a name.  The challenge file is reread
+// if it has changed.
+func (s *challengeStore) lookup(name string) []string {
+	name = dns.Fqdn(strings.ToLower(name))
+	if !strings.HasPrefix(name, acmeChallengeLabel+".") {
+		return nil
+	}
+	if s.file != "" {
+		if err := s.load(); err != nil {
+			log.Errorf("failed to load ACME challenges: %v", err)
+		}
+	}
+
+	s.mu.Lock()
+	values := make([]string, 0)
+	values = append(values, s.fromFile[name]...)
+	s.mu.Unlock()
+	return append(values, s.api.lookup(name)...)
+}
+
+// load reads the challenge file if it has changed since it was last read.
+// Each line of the file holds a name and a challenge value.  Anything after
+// a '#' is a comment.
+func (s *challengeStore) load() error {
+	info, err := os.Stat(s.file)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	unchanged := info.ModTime().Equal(s.modTime)
+	s.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	f, err := os.Open(s.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	challenges, err := parseChallenges(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", s.file, err)
+	}
+
+	s.mu.Lock()
+	s.fromFile = challenges
+	s.modTime = info.ModTime()
+	s.mu.Unlock()
+	return nil
+}
+
+// parseChallenges parses a list of challenges.
+func parseChallenges(r io.Reader) (map[string][]string, error) {
+	challenges := make(map[string][]string)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		switch len(fields) {
+		case 0:
+			continue
+		case 2:
+			name := dns.Fqdn(strings.ToLower(fields[0]))
+			challenges[name] = append(challenges[name], fields[1])
+		default:
+			return nil, fmt.Errorf("line %d: requires a name and a value", line)
+		}
+	}
+	return challenges, scanner.Err()
+}
+
+// challengeAPIs are the challenge APIs of the process by address.  An API is
+// shared by all of the server blocks that use its address, and outlives
+// reloads so that the listener is not bound twice and presented challenges
+// are kept.
+var (
+	challengeAPIsMu sync.Mutex
+	challengeAPIs   = make(map[string]*challengeAPI)
+)
+
+// challengeAPI is an HTTP API through which ACME DNS-01 challenges are
+// presented and cleaned up.
+type challengeAPI struct {
+	addr string
+
+	mu         sync.Mutex
+	listener   net.Listener
+	stores     map[*challengeStore]bool
+	challenges map[string][]acmeChallenge
+}
+
+// challengeAPIFor returns the challenge API for an address.
+func challengeAPIFor(addr string) *challengeAPI {
+	challengeAPIsMu.Lock()
+	defer challengeAPIsMu.Unlock()
+	api, exists := challengeAPIs[addr]
+	if !exists {
+		api = newChallengeAPI(addr)
+		challengeAPIs[addr] = api
+	}
+	return api
+}
+
+func newChallengeAPI(addr string) *challengeAPI {
+	return &challengeAPI{
+		addr:       addr,
+		stores:     make(map[*challengeStore]bool),
+		challenges: make(map[string][]acmeChallenge),
+	}
+}
+
+// register adds a store whose zones the API serves, starting the API if it
+// is not running.
+func (a *challengeAPI) register(s *challengeStore) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.listener == nil {
+		listener, err := net.Listen("tcp", a.addr)
+		if err != nil {
+			return err
+		}
+		a.listener = listener
+		go http.Serve(listener, a)
+	}
+	a.stores[s] = true
+	return nil
+}
+
+// unregister removes a store, stopping the API if no stores remain.
+func (a *challengeAPI) unregister(s *challengeStore) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.stores, s)
+	if len(a.stores) > 0 || a.listener == nil {
+		return nil
+	}
+	err := a.listener.Close()
+	a.listener = nil
+	return err
+}
+
+// challengeName returns the DNS name under which the challenges for a name
+// are served, or false if no store serves the name.
+func (a *challengeAPI) challengeName(name string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for s := range a.stores {
+		if dnsName, covered := s.challengeName(name); covered {
+			return dnsName, true
+		}
+	}
+	return "", false
+}
+
+// lookup returns the unexpired challenges presented for a DNS name.
+func (a *challengeAPI) lookup(name string) []string {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	values := make([]string, 0)
+	now := time.Now()
+	for _, challenge := range a.challenges[name] {
+		if now.Before(challenge.expires) {
+			values = append(values, challenge.value)
+		}
+	}
+	return values
+}
+
+// present adds a challenge.
+func (a *challengeAPI) present(name string, value string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cleanupLocked(name, value)
+	a.challenges[name] = append(a.challenges[name], acmeChallenge{value: value, expires: time.Now().Add(acmeChallengeLifetime)})
+}
+
+// cleanup removes a challenge.
+func (a *challengeAPI) cleanup(name string, value string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cleanupLocked(name, value)
+}
+
+// cleanupLocked removes a challenge along with any that have expired.  It
+// must be called with the lock held.
+func (a *challengeAPI) cleanupLocked(name string, value string) {
+	now := time.Now()
+	remaining := make([]acmeChallenge, 0, len(a.challenges[name]))
+	for _, challenge := range a.challenges[name] {
+		if challenge.value != value && now.Before(challenge.expires) {
+			remaining = append(remaining, challenge)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(a.challenges, name)
+	} else {
+		a.challenges[name] = remaining
+	}
+}
+
+// challengeRequest is a request to the challenge API.  It matches the raw
+// format of the lego httpreq DNS provider.
+type challengeRequest struct {
+	FQDN  string `json:"fqdn"`
+	Value string `json:"value"`
+}
+
+// ServeHTTP implements the challenge API, which presents challenges with a
+// POST to /present and removes them with a POST to /cleanup.  Only names
+// within the zones of the plugin are accepted.
+func (a *challengeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req challengeRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	name, covered := a.challengeName(req.FQDN)
+	if !covered || req.Value == "" {
+		http.Error(w, "invalid challenge", http.StatusBadRequest)
+		return
+	}
+	switch r.URL.Path {
+	case "/present":
+		log.Infof("presenting ACME challenge for %s", name)
+		a.present(name, req.Value)
+	case "/cleanup":
+		log.Infof("cleaning up ACME challenge for %s", name)
+		a.cleanup(name, req.Value)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// respond fills in the response to a request for challenges.
+func (s *challengeStore) respond(state request.Request, a *dns.Msg, values []string) {
+	for _, value := range values {
+		a.Answer = append(a.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: acmeChallengeTTL},
+			Txt: splitTXT(value),
+		})
+	}
+	state.SizeAndDo(a)
+}
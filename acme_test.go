@@ -0,0 +1,160 @@
+package ens
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+func TestParseChallenges(t *testing.T) {
+	challenges, err := parseChallenges(strings.NewReader(`# Challenges
+_acme-challenge.wealdtech.eth.link abc123
+_ACME-challenge.wealdtech.eth.link. def456 # Wildcard
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if values := challenges["_acme-challenge.wealdtech.eth.link."]; strings.Join(values, ",") != "abc123,def456" {
+		t.Fatalf("Unexpected challenges %v", challenges)
+	}
+
+	if _, err := parseChallenges(strings.NewReader("_acme-challenge.wealdtech.eth.link\n")); err == nil || err.Error() != "line 1: requires a name and a value" {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+func TestChallengeAPI(t *testing.T) {
+	api := newChallengeAPI("")
+	store := newChallengeStore("", []string{"eth.link."}, []suffixMapping{{dns: "eth.link.", ens: "eth."}}, api)
+	api.stores[store] = true
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	post := func(path string, body string) int {
+		resp, err := http.Post(server.URL+path, "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("Failed to post: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post("/present", `{"fqdn":"_acme-challenge.wealdtech.eth.link.","value":"abc123"}`); status != http.StatusOK {
+		t.Fatalf("Unexpected status %d", status)
+	}
+	// ENS names are mapped to their DNS names.
+	if status := post("/present", `{"fqdn":"_acme-challenge.wealdtech.eth.","value":"def456"}`); status != http.StatusOK {
+		t.Fatalf("Unexpected status %d", status)
+	}
+	if values := store.lookup("_acme-challenge.WealdTech.eth.link."); strings.Join(values, ",") != "abc123,def456" {
+		t.Fatalf("Unexpected challenges %v", values)
+	}
+	if status := post("/cleanup", `{"fqdn":"_acme-challenge.wealdtech.eth.link.","value":"abc123"}`); status != http.StatusOK {
+		t.Fatalf("Unexpected status %d", status)
+	}
+	if values := store.lookup("_acme-challenge.wealdtech.eth.link."); strings.Join(values, ",") != "def456" {
+		t.Fatalf("Unexpected challenges %v", values)
+	}
+
+	// Only challenge names within the zones are accepted.
+	if status := post("/present", `{"fqdn":"wealdtech.eth.link.","value":"abc123"}`); status != http.StatusBadRequest {
+		t.Fatalf("Unexpected status %d", status)
+	}
+	if status := post("/present", `{"fqdn":"_acme-challenge.example.com.","value":"abc123"}`); status != http.StatusBadRequest {
+		t.Fatalf("Unexpected status %d", status)
+	}
+	if status := post("/other", `{"fqdn":"_acme-challenge.wealdtech.eth.link.","value":"abc123"}`); status != http.StatusNotFound {
+		t.Fatalf("Unexpected status %d", status)
+	}
+
+	// Challenges expire if they are not cleaned up.
+	api.challenges["_acme-challenge.wealdtech.eth.link."][0].expires = time.Now().Add(-time.Second)
+	if values := store.lookup("_acme-challenge.wealdtech.eth.link."); len(values) != 0 {
+		t.Fatalf("Unexpected challenges %v", values)
+	}
+}
+
+func TestChallengeAPIShared(t *testing.T) {
+	api := challengeAPIFor("127.0.0.1:0")
+	if challengeAPIFor("127.0.0.1:0") != api {
+		t.Fatalf("API not shared")
+	}
+	old := newChallengeStore("", []string{"eth.link."}, nil, api)
+	if err := api.register(old); err != nil {
+		t.Fatalf("Failed to start API: %v", err)
+	}
+	listener := api.listener
+	api.present("_acme-challenge.wealdtech.eth.link.", "abc123")
+
+	// A reload starts the new instance before shutting down the old one.
+	reloaded := newChallengeStore("", []string{"eth.link."}, nil, api)
+	if err := api.register(reloaded); err != nil {
+		t.Fatalf("Failed to register store: %v", err)
+	}
+	if err := api.unregister(old); err != nil {
+		t.Fatalf("Failed to unregister store: %v", err)
+	}
+	if api.listener != listener {
+		t.Fatalf("Listener not kept across reload")
+	}
+	if values := reloaded.lookup("_acme-challenge.wealdtech.eth.link."); strings.Join(values, ",") != "abc123" {
+		t.Fatalf("Challenges not kept across reload: %v", values)
+	}
+
+	if err := api.unregister(reloaded); err != nil {
+		t.Fatalf("Failed to stop API: %v", err)
+	}
+	if api.listener != nil {
+		t.Fatalf("Listener not closed")
+	}
+}
+
+func TestChallengeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "challenges")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "challenges.txt")
+	if err := ioutil.WriteFile(file, []byte("_acme-challenge.wealdtech.eth.link. abc123\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	e := ENS{
+		Next:       test.NextHandler(dns.RcodeRefused, nil),
+		Zones:      []string{"eth.link."},
+		Challenges: newChallengeStore(file, []string{"eth.link."}, nil, nil),
+	}
+	r := new(dns.Msg)
+	r.SetQuestion("_acme-challenge.wealdtech.eth.link.", dns.TypeTXT)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := e.ServeDNS(context.Background(), rec, r); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rec.Msg.Answer) != 1 || strings.Join(rec.Msg.Answer[0].(*dns.TXT).Txt, "") != "abc123" {
+		t.Fatalf("Unexpected answer %v", rec.Msg.Answer)
+	}
+
+	// Changes to the file are picked up.
+	if err := ioutil.WriteFile(file, []byte("_acme-challenge.wealdtech.eth.link. def456\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatalf("Failed to update file time: %v", err)
+	}
+	if values := e.Challenges.lookup("_acme-challenge.wealdtech.eth.link."); strings.Join(values, ",") != "def456" {
+		t.Fatalf("Unexpected challenges %v", values)
+	}
+}
@@ -0,0 +1,227 @@
+package ens
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultRPCTimeout is the default maximum time allowed for a single call to
+// the Ethereum node.
+const defaultRPCTimeout = 2 * time.Second
+
+// instrumentedBackend is a contract backend that records metrics and traces
+// for the calls it makes to the Ethereum node, and bounds the time they take.
+type instrumentedBackend struct {
+	bind.ContractBackend
+	// timeout is the maximum time allowed for a single call; 0 for no limit.
+	timeout time.Duration
+	// calls deduplicates concurrent identical contract calls.
+	calls singleflight.Group
+}
+
+// withTimeout bounds the context of a single call to the Ethereum node.
+func (b *instrumentedBackend) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if b.timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, b.timeout)
+}
+
+// CodeAt returns the code of the given account.
+func (b *instrumentedBackend) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	span, ctx := startSpan(ctx, "eth_getCode")
+	defer span.Finish()
+	ctx, cancel := b.withTimeout(ctx)
+	defer cancel()
+	span.SetTag("address", contract.Hex())
+	started := time.Now()
+	res, err := b.ContractBackend.CodeAt(ctx, contract, blockNumber)
+	observeRPC(ctx, span, "eth_getCode", started, err)
+	rpcFailureFrom(ctx).record(err)
+	return res, err
+}
+
+// CallContract executes an Ethereum contract call.  Concurrent identical
+// calls share a single call to the Ethereum node.
+func (b *instrumentedBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	started := time.Now()
+	leader := false
+	ch := b.calls.DoChan(callKey(call, blockNumber), func() (interface{}, error) {
+		leader = true
+		// The call outlives the caller if it is shared, so it is bounded
+		// only by the call timeout.
+		return b.callContract(detachedContext{ctx}, call, blockNumber)
+	})
+	select {
+	case res := <-ch:
+		if !leader {
+			rpcSharedCount.WithLabelValues("eth_call").Inc()
+			observeSharedRPC(ctx, "eth_call", call, started)
+		}
+		if res.Err != nil {
+			rpcFailureFrom(ctx).record(res.Err)
+			return nil, res.Err
+		}
+		return common.CopyBytes(res.Val.([]byte)), nil
+	case <-ctx.Done():
+		rpcFailureFrom(ctx).record(ctx.Err())
+		return nil, ctx.Err()
+	}
+}
+
+func (b *instrumentedBackend) callContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	span, ctx := startSpan(ctx, "eth_call")
+	defer span.Finish()
+	ctx, cancel := b.withTimeout(ctx)
+	defer cancel()
+	if call.To != nil {
+		span.SetTag("to", call.To.Hex())
+	}
+	started := time.Now()
+	res, err := b.ContractBackend.CallContract(ctx, call, blockNumber)
+	observeRPC(ctx, span, "eth_call", started, err)
+	return res, err
+}
+
+// callKey is the key that identifies identical contract calls.
+func callKey(call ethereum.CallMsg, blockNumber *big.Int) string {
+	to := "create"
+	if call.To != nil {
+		to = call.To.Hex()
+	}
+	block := "latest"
+	if blockNumber != nil {
+		block = blockNumber.String()
+	}
+	return fmt.Sprintf("%s/%s/%x", block, to, call.Data)
+}
+
+// HeaderByNumber returns a block header.
+func (b *instrumentedBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	span, ctx := startSpan(ctx, "eth_getBlockByNumber")
+	defer span.Finish()
+	ctx, cancel := b.withTimeout(ctx)
+	defer cancel()
+	started := time.Now()
+	res, err := b.ContractBackend.HeaderByNumber(ctx, number)
+	observeRPC(ctx, span, "eth_getBlockByNumber", started, err)
+	rpcFailureFrom(ctx).record(err)
+	return res, err
+}
+
+// observeRPC records the metrics and query log information for a call to the
+// Ethereum node, and marks its span if it failed.
+func observeRPC(ctx context.Context, span ot.Span, method string, started time.Time, err error) {
+	duration := time.Since(started)
+	rpcCount.WithLabelValues(method).Inc()
+	rpcDuration.WithLabelValues(method).Observe(duration.Seconds())
+	queryLogEntryFrom(ctx).addRPC(duration)
+	if err != nil {
+		rpcErrorCount.WithLabelValues(method).Inc()
+		ext.Error.Set(span, true)
+		span.LogKV("error", err.Error())
+	}
+}
+
+// observeSharedRPC records the trace and query log information for a call
+// answered by an identical call made for another request, which is recorded
+// against that request.  The time recorded is the time spent waiting.
+func observeSharedRPC(ctx context.Context, method string, call ethereum.CallMsg, started time.Time) {
+	if parent := ot.SpanFromContext(ctx); parent != nil {
+		span := parent.Tracer().StartSpan(method, ot.ChildOf(parent.Context()), ot.StartTime(started))
+		span.SetTag("shared", true)
+		if call.To != nil {
+			span.SetTag("to", call.To.Hex())
+		}
+		span.Finish()
+	}
+	queryLogEntryFrom(ctx).addSharedRPC(time.Since(started))
+}
+
+// isRevert returns true if an error from the Ethereum node reports that a
+// call was executed but failed, for example because the contract does not
+// implement the function called, rather than that the call could not be
+// made.
+func isRevert(err error) bool {
+	rpcErr, isRPCError := err.(rpc.Error)
+	if !isRPCError {
+		return false
+	}
+	if rpcErr.ErrorCode() == 3 {
+		return true
+	}
+	msg := strings.ToLower(rpcErr.Error())
+	return strings.Contains(msg, "execution reverted") ||
+		strings.Contains(msg, "invalid opcode") ||
+		strings.Contains(msg, "invalid jump destination")
+}
+
+// rpcFailureKey is the context key for the RPC failure of a request.
+type rpcFailureKey struct{}
+
+// rpcFailure is the first call to the Ethereum node that could not be made
+// for a request.  Lookups treat failed calls as missing data, so a request
+// with a failure cannot be answered with confidence.
+type rpcFailure struct {
+	mu  sync.Mutex
+	err error
+}
+
+// withRPCFailure returns a context that carries the given RPC failure.
+func withRPCFailure(ctx context.Context, f *rpcFailure) context.Context {
+	return context.WithValue(ctx, rpcFailureKey{}, f)
+}
+
+// rpcFailureFrom returns the RPC failure in the context, or nil if there is
+// none.  All methods of a nil failure are no-ops.
+func rpcFailureFrom(ctx context.Context) *rpcFailure {
+	f, _ := ctx.Value(rpcFailureKey{}).(*rpcFailure)
+	return f
+}
+
+// record records the error from a call, unless the call was made and
+// reverted.
+func (f *rpcFailure) record(err error) {
+	if f == nil || err == nil || isRevert(err) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// failed returns the error of the first failed call, if any.
+func (f *rpcFailure) failed() error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// detachedContext is a context that carries the values of its parent but not
+// its deadline or cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
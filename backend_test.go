@@ -0,0 +1,209 @@
+package ens
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// mockBackend is a contract backend that returns canned results for calls.
+type mockBackend struct {
+	bind.ContractBackend
+	result []byte
+	err    error
+	calls  int32
+}
+
+func (m *mockBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	atomic.AddInt32(&m.calls, 1)
+	return m.result, m.err
+}
+
+func TestInstrumentedBackend(t *testing.T) {
+	calls := testutil.ToFloat64(rpcCount.WithLabelValues("eth_call"))
+	errs := testutil.ToFloat64(rpcErrorCount.WithLabelValues("eth_call"))
+
+	backend := &instrumentedBackend{ContractBackend: &mockBackend{result: []byte{0x01}}}
+	if _, err := backend.CallContract(context.Background(), ethereum.CallMsg{}, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	backend = &instrumentedBackend{ContractBackend: &mockBackend{err: errors.New("failed")}}
+	if _, err := backend.CallContract(context.Background(), ethereum.CallMsg{}, nil); err == nil {
+		t.Fatalf("Expected error not returned")
+	}
+
+	if diff := testutil.ToFloat64(rpcCount.WithLabelValues("eth_call")) - calls; diff != 2 {
+		t.Errorf("Unexpected change in call count: %v", diff)
+	}
+	if diff := testutil.ToFloat64(rpcErrorCount.WithLabelValues("eth_call")) - errs; diff != 1 {
+		t.Errorf("Unexpected change in error count: %v", diff)
+	}
+}
+
+// blockingBackend is a contract backend whose calls block until cancelled.
+type blockingBackend struct {
+	bind.ContractBackend
+}
+
+func (m *blockingBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestInstrumentedBackendTimeout(t *testing.T) {
+	backend := &instrumentedBackend{ContractBackend: &blockingBackend{}, timeout: 10 * time.Millisecond}
+	started := time.Now()
+	if _, err := backend.CallContract(context.Background(), ethereum.CallMsg{}, nil); err != context.DeadlineExceeded {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("Call took %v", elapsed)
+	}
+}
+
+// countingBackend is a contract backend that counts its calls, each of which
+// takes a short time.
+type countingBackend struct {
+	bind.ContractBackend
+	calls int32
+}
+
+func (m *countingBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	atomic.AddInt32(&m.calls, 1)
+	time.Sleep(50 * time.Millisecond)
+	return call.Data, nil
+}
+
+func TestInstrumentedBackendShared(t *testing.T) {
+	mock := &countingBackend{}
+	backend := &instrumentedBackend{ContractBackend: mock}
+	to := common.HexToAddress("0x0000000000000000000000000000000000000001")
+
+	// Concurrent requests for two popular names.
+	const requests = 100
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(data byte) {
+			defer wg.Done()
+			res, err := backend.CallContract(context.Background(), ethereum.CallMsg{To: &to, Data: []byte{data}}, nil)
+			if err == nil && (len(res) != 1 || res[0] != data) {
+				err = fmt.Errorf("unexpected result %x for %x", res, data)
+			}
+			errs <- err
+		}(byte(i % 2))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	calls := atomic.LoadInt32(&mock.calls)
+	t.Logf("%d requests resulted in %d backend calls", requests, calls)
+	if calls >= requests/2 {
+		t.Errorf("Backend calls not deduplicated: %d calls for %d requests", calls, requests)
+	}
+}
+
+func TestCallKey(t *testing.T) {
+	to := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	other := common.HexToAddress("0x0000000000000000000000000000000000000002")
+	base := callKey(ethereum.CallMsg{To: &to, Data: []byte{0x01}}, nil)
+	if callKey(ethereum.CallMsg{To: &to, Data: []byte{0x01}}, nil) != base {
+		t.Errorf("Identical calls have different keys")
+	}
+	if callKey(ethereum.CallMsg{To: &other, Data: []byte{0x01}}, nil) == base {
+		t.Errorf("Calls to different contracts have the same key")
+	}
+	if callKey(ethereum.CallMsg{To: &to, Data: []byte{0x02}}, nil) == base {
+		t.Errorf("Calls with different data have the same key")
+	}
+	if callKey(ethereum.CallMsg{To: &to, Data: []byte{0x01}}, big.NewInt(1)) == base {
+		t.Errorf("Calls at different blocks have the same key")
+	}
+}
+
+func TestRPCFailure(t *testing.T) {
+	failure := &rpcFailure{}
+	ctx := withRPCFailure(context.Background(), failure)
+
+	// Reverted calls are not failures.
+	backend := &instrumentedBackend{ContractBackend: &mockBackend{err: revertError{}}}
+	if _, err := backend.CallContract(ctx, ethereum.CallMsg{Data: []byte{0x01}}, nil); err == nil {
+		t.Fatalf("Expected error not returned")
+	}
+	if err := failure.failed(); err != nil {
+		t.Fatalf("Unexpected failure: %v", err)
+	}
+
+	backend = &instrumentedBackend{ContractBackend: &mockBackend{err: errors.New("header not found")}}
+	if _, err := backend.CallContract(ctx, ethereum.CallMsg{Data: []byte{0x02}}, nil); err == nil {
+		t.Fatalf("Expected error not returned")
+	}
+	if err := failure.failed(); err == nil || err.Error() != "header not found" {
+		t.Fatalf("Unexpected failure: %v", err)
+	}
+}
+
+// gatedBackend is a contract backend whose calls wait until released.
+type gatedBackend struct {
+	bind.ContractBackend
+	calls   int32
+	release chan struct{}
+}
+
+func (m *gatedBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	atomic.AddInt32(&m.calls, 1)
+	<-m.release
+	return call.Data, nil
+}
+
+func TestInstrumentedBackendSharedQueryLog(t *testing.T) {
+	mock := &gatedBackend{release: make(chan struct{})}
+	backend := &instrumentedBackend{ContractBackend: mock}
+	to := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	entries := []*queryLogEntry{{started: time.Now()}, {started: time.Now()}}
+
+	var wg sync.WaitGroup
+	call := func(entry *queryLogEntry) {
+		defer wg.Done()
+		ctx := withQueryLogEntry(context.Background(), entry)
+		if _, err := backend.CallContract(ctx, ethereum.CallMsg{To: &to, Data: []byte{0x01}}, nil); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	wg.Add(2)
+	go call(entries[0])
+	for atomic.LoadInt32(&mock.calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go call(entries[1])
+	// Allow the second call to join the first before it completes.
+	time.Sleep(20 * time.Millisecond)
+	close(mock.release)
+	wg.Wait()
+
+	if calls := atomic.LoadInt32(&mock.calls); calls != 1 {
+		t.Fatalf("Unexpected backend calls %d", calls)
+	}
+	if entries[0].RPCCalls != 1 || entries[0].RPCShared != 0 {
+		t.Errorf("Unexpected leader entry %d/%d", entries[0].RPCCalls, entries[0].RPCShared)
+	}
+	if entries[1].RPCCalls != 0 || entries[1].RPCShared != 1 || entries[1].RPCTime <= 0 {
+		t.Errorf("Unexpected follower entry %d/%d/%v", entries[1].RPCCalls, entries[1].RPCShared, entries[1].RPCTime)
+	}
+}
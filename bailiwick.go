@@ -0,0 +1,28 @@
+package ens
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// inBailiwick returns the records that match the name, class and type of the
+// question they answer.  Anyone who controls a resolver contract can return
+// arbitrary records, so those that do not match are dropped.
+func inBailiwick(rrs []dns.RR, name string, qtype uint16) []dns.RR {
+	results := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		switch {
+		case !strings.EqualFold(hdr.Name, name):
+			log.Warningf("dropping record for %s: owner name does not match %s", name, hdr.Name)
+		case hdr.Class != dns.ClassINET:
+			log.Warningf("dropping record for %s: unexpected class %s", name, dns.ClassToString[hdr.Class])
+		case hdr.Rrtype != qtype && qtype != dns.TypeANY:
+			log.Warningf("dropping record for %s: unexpected type %s (expected %s)", name, dns.TypeToString[hdr.Rrtype], dns.TypeToString[qtype])
+		default:
+			results = append(results, rr)
+		}
+	}
+	return results
+}
@@ -0,0 +1,36 @@
+package ens
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestInBailiwick(t *testing.T) {
+	tests := []struct {
+		rr       string
+		qtype    uint16
+		accepted bool
+	}{
+		{"www.wealdtech.eth. 3600 IN A 1.2.3.4", dns.TypeA, true},
+		{"WWW.Wealdtech.ETH. 3600 IN A 1.2.3.4", dns.TypeA, true},
+		{"www.wealdtech.eth. 3600 IN A 1.2.3.4", dns.TypeANY, true},
+		{"www.google.com. 3600 IN A 1.2.3.4", dns.TypeA, false},
+		{"wealdtech.eth. 3600 IN A 1.2.3.4", dns.TypeA, false},
+		{"sub.www.wealdtech.eth. 3600 IN A 1.2.3.4", dns.TypeA, false},
+		{"www.wealdtech.eth. 3600 CH A 1.2.3.4", dns.TypeA, false},
+		{"www.wealdtech.eth. 3600 IN AAAA ::1", dns.TypeA, false},
+		{"www.wealdtech.eth. 3600 IN NS ns1.google.com.", dns.TypeA, false},
+	}
+
+	for i, tt := range tests {
+		rr, err := dns.NewRR(tt.rr)
+		if err != nil {
+			t.Fatalf("Test %d: failed to create RR: %v", i, err)
+		}
+		results := inBailiwick([]dns.RR{rr}, "www.wealdtech.eth.", tt.qtype)
+		if (len(results) == 1) != tt.accepted {
+			t.Errorf("Test %d: accepted %t (expected %t)", i, len(results) == 1, tt.accepted)
+		}
+	}
+}
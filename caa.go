@@ -0,0 +1,39 @@
+package ens
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+)
+
+// handleCAA answers CAA requests for a domain with a contenthash.  Records
+// held on-chain are used if present, otherwise the configured CAA records are
+// returned so that certificates can be issued for the gateway serving the
+// domain.
+func (e ENS) handleCAA(ctx context.Context, name string, domain string, contentHash []byte) ([]dns.RR, error) {
+	results := make([]dns.RR, 0)
+
+	rrSet, err := e.obtainRRSet(ctx, name, domain, dns.TypeCAA)
+	if err == nil && len(rrSet) != 0 {
+		// We have an on-chain rrset; use it
+		results, err = unpackRRs(rrSet)
+		if err != nil {
+			log.Warningf("invalid CAA records for %s: %v", name, err)
+		}
+		return inBailiwick(results, name, dns.TypeCAA), nil
+	}
+
+	if len(e.CAAs) == 0 {
+		return results, nil
+	}
+	ttl := e.ttl(ctx, domain, dns.TypeCAA)
+	for _, caa := range e.CAAs {
+		results = append(results, &dns.CAA{
+			Hdr:   dns.RR_Header{Name: name, Rrtype: dns.TypeCAA, Class: dns.ClassINET, Ttl: ttl},
+			Flag:  caa.Flag,
+			Tag:   caa.Tag,
+			Value: caa.Value,
+		})
+	}
+	return results, nil
+}
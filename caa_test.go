@@ -0,0 +1,44 @@
+package ens
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/miekg/dns"
+	ens "github.com/wealdtech/go-ens/v3"
+)
+
+func TestHandleCAA(t *testing.T) {
+	// A resolver without DNS record support.
+	backend := &methodBackend{results: map[string][]byte{
+		"resolver(bytes32)":         abiWords(common.HexToAddress("0x42D63ae25990889E35F215bC95884039Ba354115")),
+		"supportsInterface(bytes4)": abiWords(0),
+	}}
+	registry, err := ens.NewRegistry(backend)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+
+	e := ENS{Backend: backend, Registry: registry}
+	results, err := e.handleCAA(context.Background(), "caa.test.eth.", "caa.test.eth.", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("Unexpected records %v", results)
+	}
+
+	e.CAAs = []dns.CAA{{Flag: 0, Tag: "issue", Value: "letsencrypt.org"}, {Flag: 128, Tag: "iodef", Value: "mailto:security@example.com"}}
+	e.TTLs = map[uint16]uint32{dns.TypeCAA: 300}
+	results, err = e.handleCAA(context.Background(), "caa.test.eth.", "caa.test.eth.", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Unexpected records %v", results)
+	}
+	if results[1].String() != "caa.test.eth.\t300\tIN\tCAA\t128 iodef \"mailto:security@example.com\"" {
+		t.Fatalf("Unexpected record %q", results[1].String())
+	}
+}
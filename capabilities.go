@@ -0,0 +1,283 @@
+package ens
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	lru "github.com/hashicorp/golang-lru"
+	ens "github.com/wealdtech/go-ens/v3"
+	dnsresolvercontract "github.com/wealdtech/go-ens/v3/contracts/dnsresolver"
+	resolvercontract "github.com/wealdtech/go-ens/v3/contracts/resolver"
+)
+
+// capabilities is a set of resolver interfaces supported by a resolver.
+type capabilities uint16
+
+const (
+	// capAddr is the Ethereum address interface (EIP-137).
+	capAddr capabilities = 1 << iota
+	// capMulticoin is the multicoin address interface (ENSIP-9).
+	capMulticoin
+	// capContenthash is the contenthash interface (ENSIP-7).
+	capContenthash
+	// capContent is the legacy content interface.
+	capContent
+	// capMultihash is the legacy multihash interface.
+	capMultihash
+	// capText is the text record interface (ENSIP-5).
+	capText
+	// capDNS is the DNS record interface (EIP-1185).
+	capDNS
+	// capExtended is the extended resolver interface (ENSIP-10).
+	capExtended
+)
+
+// resolverInterfaces are the ERC-165 interface IDs of the resolver capabilities.
+var resolverInterfaces = []struct {
+	capability capabilities
+	name       string
+	id         [4]byte
+}{
+	{capAddr, "addr", [4]byte{0x3b, 0x3b, 0x57, 0xde}},
+	{capMulticoin, "multicoin", [4]byte{0xf1, 0xcb, 0x7e, 0x06}},
+	{capContenthash, "contenthash", [4]byte{0xbc, 0x1c, 0x58, 0xd1}},
+	{capContent, "content", [4]byte{0xd8, 0x38, 0x9d, 0xc5}},
+	{capMultihash, "multihash", [4]byte{0xe8, 0x94, 0x01, 0xa1}},
+	{capText, "text", [4]byte{0x59, 0xd1, 0xd4, 0x3c}},
+	{capDNS, "dns", [4]byte{0xa8, 0xfa, 0x56, 0x82}},
+	{capExtended, "extended", [4]byte{0x90, 0x61, 0xb9, 0x23}},
+}
+
+// erc165InterfaceID is the interface ID of ERC-165 itself, which is also the
+// selector of supportsInterface(bytes4).
+var erc165InterfaceID = [4]byte{0x01, 0xff, 0xc9, 0xa7}
+
+// invalidInterfaceID is an interface ID that ERC-165 contracts must report as
+// unsupported.
+var invalidInterfaceID = [4]byte{0xff, 0xff, 0xff, 0xff}
+
+// supports returns true if all of the required capabilities are present.
+func (c capabilities) supports(required capabilities) bool {
+	return c&required == required
+}
+
+// String returns the names of the capabilities.
+func (c capabilities) String() string {
+	names := make([]string, 0)
+	for _, iface := range resolverInterfaces {
+		if c.supports(iface.capability) {
+			names = append(names, iface.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+var errNoResolver = errors.New("no resolver")
+
+// resolverInfo is a resolver contract and the capabilities it supports.
+type resolverInfo struct {
+	address      common.Address
+	capabilities capabilities
+	resolver     *ens.Resolver
+	dnsResolver  *ens.DNSResolver
+	// parent is the ancestor whose extended resolver resolves the domain,
+	// if the domain does not have a resolver of its own.
+	parent string
+}
+
+// resolverCache holds the resolver address for each domain.
+var resolverCache *lru.Cache
+
+// capabilityCache holds the resolver information for each resolver address.
+var capabilityCache *lru.Cache
+
+// capabilityCacheTTL is the time for which the capabilities of a resolver are
+// cached.  Resolvers behind upgradeable proxies can change their interfaces.
+const capabilityCacheTTL = 10 * time.Minute
+
+// capabilityCacheEntry is the cached information for a resolver address.
+type capabilityCacheEntry struct {
+	info    *resolverInfo
+	fetched time.Time
+}
+
+func init() {
+	resolverCache, _ = lru.NewWithEvict(16, func(key interface{}, value interface{}) {
+		cacheEvictions.WithLabelValues("resolver").Inc()
+	})
+	capabilityCache, _ = lru.NewWithEvict(256, func(key interface{}, value interface{}) {
+		cacheEvictions.WithLabelValues("capabilities").Inc()
+	})
+}
+
+// getResolver obtains the resolver for a domain, if it supports the required
+// capabilities.
+func (e ENS) getResolver(ctx context.Context, domain string, required capabilities) (*ens.Resolver, error) {
+	info, err := e.resolverInfo(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	if !info.capabilities.supports(required) {
+		return nil, fmt.Errorf("resolver %s does not support %v", info.address.Hex(), required)
+	}
+	return info.resolver, nil
+}
+
+// getDNSResolver obtains the resolver for a domain, if it supports DNS records.
+func (e ENS) getDNSResolver(ctx context.Context, domain string) (*ens.DNSResolver, error) {
+	info, err := e.resolverInfo(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	if !info.capabilities.supports(capDNS) {
+		return nil, fmt.Errorf("resolver %s does not support %v", info.address.Hex(), capDNS)
+	}
+	return info.dnsResolver, nil
+}
+
+// resolverInfo obtains the resolver for a domain along with its capabilities.
+// A domain without a resolver of its own is resolved by the resolver of its
+// closest ancestor that has one, if that resolver is an extended resolver.
+func (e ENS) resolverInfo(ctx context.Context, domain string) (*resolverInfo, error) {
+	address, err := e.cachedResolverAddress(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	if address != ens.UnknownAddress {
+		return e.capabilities(ctx, address)
+	}
+
+	// Top-level domains are not considered.
+	for parent := parentDomain(domain); strings.Contains(parent, "."); parent = parentDomain(parent) {
+		address, err := e.cachedResolverAddress(ctx, parent)
+		if err != nil {
+			return nil, err
+		}
+		if address == ens.UnknownAddress {
+			continue
+		}
+		info, err := e.capabilities(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		if !info.capabilities.supports(capExtended) {
+			return nil, errNoResolver
+		}
+		return e.extendedResolverInfo(domain, parent, info)
+	}
+	return nil, errNoResolver
+}
+
+// cachedResolverAddress obtains the address of the resolver of a domain,
+// which is the unknown address if the domain does not have one.
+func (e ENS) cachedResolverAddress(ctx context.Context, domain string) (common.Address, error) {
+	if cached, exists := resolverCache.Get(domain); exists {
+		cacheHits.WithLabelValues("resolver").Inc()
+		return cached.(common.Address), nil
+	}
+	cacheMisses.WithLabelValues("resolver").Inc()
+	address, err := e.resolverAddress(ctx, domain)
+	if err != nil {
+		return ens.UnknownAddress, err
+	}
+	resolverCache.Add(domain, address)
+	return address, nil
+}
+
+// capabilities obtains the resolver information for a resolver address.
+func (e ENS) capabilities(ctx context.Context, address common.Address) (*resolverInfo, error) {
+	if cached, exists := capabilityCache.Get(address); exists {
+		if entry := cached.(*capabilityCacheEntry); time.Since(entry.fetched) < capabilityCacheTTL {
+			cacheHits.WithLabelValues("capabilities").Inc()
+			return entry.info, nil
+		}
+	}
+	cacheMisses.WithLabelValues("capabilities").Inc()
+	info, err := e.probeResolver(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("resolver %s supports %v", address.Hex(), info.capabilities)
+	capabilityCache.Add(address, &capabilityCacheEntry{info: info, fetched: time.Now()})
+	return info, nil
+}
+
+// parentDomain returns the parent of a domain, or "" if it has none.
+func parentDomain(domain string) string {
+	if dot := strings.Index(domain, "."); dot != -1 {
+		return domain[dot+1:]
+	}
+	return ""
+}
+
+// probeResolver obtains the capabilities of a resolver using ERC-165.
+func (e ENS) probeResolver(ctx context.Context, address common.Address) (*resolverInfo, error) {
+	info := &resolverInfo{address: address}
+
+	supported, err := e.supportsInterface(ctx, address, erc165InterfaceID)
+	if err != nil {
+		return nil, err
+	}
+	if supported {
+		// A contract that claims to support every interface does not
+		// implement ERC-165.
+		supported, err = e.supportsInterface(ctx, address, invalidInterfaceID)
+		if err != nil {
+			return nil, err
+		}
+		supported = !supported
+	}
+	if supported {
+		for _, iface := range resolverInterfaces {
+			supported, err := e.supportsInterface(ctx, address, iface.id)
+			if err != nil {
+				return nil, err
+			}
+			if supported {
+				info.capabilities |= iface.capability
+			}
+		}
+	}
+
+	contract, err := resolvercontract.NewContract(address, e.Backend)
+	if err != nil {
+		return nil, err
+	}
+	info.resolver = &ens.Resolver{Contract: contract, ContractAddr: address}
+	dnsContract, err := dnsresolvercontract.NewContract(address, e.Backend)
+	if err != nil {
+		return nil, err
+	}
+	info.dnsResolver = &ens.DNSResolver{Contract: dnsContract, ContractAddr: address}
+
+	return info, nil
+}
+
+// supportsInterface calls supportsInterface on a contract.  Contracts that
+// do not implement the call, including addresses without code, are reported
+// as not supporting the interface; failures to make the call, including
+// errors reported by the node such as rate limits, are returned so that they
+// are not cached.
+func (e ENS) supportsInterface(ctx context.Context, address common.Address, id [4]byte) (bool, error) {
+	data := make([]byte, 36)
+	copy(data, erc165InterfaceID[:])
+	copy(data[4:], id[:])
+	res, err := e.Backend.CallContract(ctx, ethereum.CallMsg{To: &address, Data: data}, nil)
+	if err != nil {
+		if isRevert(err) {
+			// The call was made, but reverted.
+			return false, nil
+		}
+		return false, err
+	}
+	return len(res) == 32 && common.BytesToHash(res) == common.BigToHash(big.NewInt(1)), nil
+}
@@ -0,0 +1,294 @@
+package ens
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	ens "github.com/wealdtech/go-ens/v3"
+)
+
+// revertError is the error returned by the Ethereum node for a reverted call.
+type revertError struct{}
+
+func (revertError) Error() string  { return "execution reverted" }
+func (revertError) ErrorCode() int { return 3 }
+
+// nodeError is an error reported by the Ethereum node that is not a revert.
+type nodeError struct{}
+
+func (nodeError) Error() string  { return "rate limit exceeded" }
+func (nodeError) ErrorCode() int { return -32005 }
+
+// resolverBackend is a contract backend with a single resolver that supports
+// the given interfaces.
+type resolverBackend struct {
+	bind.ContractBackend
+	resolver  common.Address
+	supported map[[4]byte]bool
+	err       error
+	calls     int
+}
+
+func (m *resolverBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	m.calls++
+	if m.err != nil {
+		return nil, m.err
+	}
+	if *call.To != m.resolver {
+		// A call to the registry for the resolver.
+		return common.LeftPadBytes(m.resolver.Bytes(), 32), nil
+	}
+	var id [4]byte
+	copy(id[:], call.Data[4:8])
+	if m.supported == nil {
+		return nil, revertError{}
+	}
+	if m.supported[id] {
+		return common.LeftPadBytes([]byte{0x01}, 32), nil
+	}
+	return make([]byte, 32), nil
+}
+
+func TestProbeResolver(t *testing.T) {
+	tests := []struct {
+		name         string
+		supported    map[[4]byte]bool
+		err          error
+		capabilities string
+		probeErr     bool
+	}{
+		{
+			name: "PublicResolver",
+			supported: map[[4]byte]bool{
+				erc165InterfaceID:        true,
+				{0x3b, 0x3b, 0x57, 0xde}: true,
+				{0xf1, 0xcb, 0x7e, 0x06}: true,
+				{0xbc, 0x1c, 0x58, 0xd1}: true,
+				{0x59, 0xd1, 0xd4, 0x3c}: true,
+				{0xa8, 0xfa, 0x56, 0x82}: true,
+			},
+			capabilities: "addr,multicoin,contenthash,text,dns",
+		},
+		{
+			name: "LegacyResolver",
+			supported: map[[4]byte]bool{
+				erc165InterfaceID:        true,
+				{0x3b, 0x3b, 0x57, 0xde}: true,
+				{0xd8, 0x38, 0x9d, 0xc5}: true,
+			},
+			capabilities: "addr,content",
+		},
+		{
+			name: "ExtendedResolver",
+			supported: map[[4]byte]bool{
+				erc165InterfaceID:        true,
+				{0x90, 0x61, 0xb9, 0x23}: true,
+			},
+			capabilities: "extended",
+		},
+		{
+			name: "SupportsEverything",
+			supported: map[[4]byte]bool{
+				erc165InterfaceID:        true,
+				invalidInterfaceID:       true,
+				{0x3b, 0x3b, 0x57, 0xde}: true,
+			},
+			capabilities: "none",
+		},
+		{
+			name: "NoERC165",
+			supported: map[[4]byte]bool{
+				{0x3b, 0x3b, 0x57, 0xde}: true,
+			},
+			capabilities: "none",
+		},
+		{
+			name:         "Reverts",
+			capabilities: "none",
+		},
+		{
+			name:     "NodeFailure",
+			err:      errors.New("connection refused"),
+			probeErr: true,
+		},
+		{
+			name:     "NodeError",
+			err:      nodeError{},
+			probeErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address := common.HexToAddress("0x4976fb03C32e5B8cfe2b6cCB31c09Ba78EBaBa41")
+			backend := &resolverBackend{resolver: address, supported: test.supported, err: test.err}
+			info, err := ENS{Backend: backend}.probeResolver(context.Background(), address)
+			if test.probeErr {
+				if err == nil {
+					t.Fatalf("Expected error not returned")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if info.capabilities.String() != test.capabilities {
+				t.Fatalf("Unexpected capabilities %v (expected %s)", info.capabilities, test.capabilities)
+			}
+		})
+	}
+}
+
+func TestResolverInfoCache(t *testing.T) {
+	address := common.HexToAddress("0x226159d592E2b063810a10Ebf6dcbADA94Ed68b8")
+	backend := &resolverBackend{resolver: address, supported: map[[4]byte]bool{
+		erc165InterfaceID:        true,
+		{0xbc, 0x1c, 0x58, 0xd1}: true,
+	}}
+	registry, err := ens.NewRegistry(backend)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	e := ENS{Backend: backend, Registry: registry}
+
+	if _, err := e.getResolver(context.Background(), "cache.test.eth", capContenthash); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	calls := backend.calls
+	if _, err := e.getResolver(context.Background(), "cache.test.eth", capContenthash); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if backend.calls != calls {
+		t.Fatalf("Resolver information not cached; %d calls made", backend.calls-calls)
+	}
+
+	// A second domain with the same resolver needs only the resolver address.
+	if _, err := e.getResolver(context.Background(), "cache2.test.eth", capContenthash); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if backend.calls != calls+1 {
+		t.Fatalf("Capabilities not cached; %d calls made", backend.calls-calls)
+	}
+
+	// Capabilities are probed again once they are stale.
+	cached, _ := capabilityCache.Get(address)
+	cached.(*capabilityCacheEntry).fetched = time.Now().Add(-capabilityCacheTTL)
+	calls = backend.calls
+	if _, err := e.getResolver(context.Background(), "cache2.test.eth", capContenthash); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if backend.calls == calls {
+		t.Fatalf("Stale capabilities not probed")
+	}
+
+	if _, err := e.getResolver(context.Background(), "cache.test.eth", capText); err == nil {
+		t.Fatalf("Resolver returned for unsupported capability")
+	}
+	if _, err := e.getDNSResolver(context.Background(), "cache.test.eth"); err == nil {
+		t.Fatalf("DNS resolver returned for unsupported capability")
+	}
+}
+
+// wildcardBackend is a contract backend with resolvers for the given domains,
+// each of which supports the given interfaces, and resolves names with
+// resolve() by returning the given contenthash.
+type wildcardBackend struct {
+	bind.ContractBackend
+	resolvers   map[string]common.Address
+	supported   map[common.Address]map[[4]byte]bool
+	contenthash []byte
+	// name and data are the arguments of the last call to resolve().
+	name []byte
+	data []byte
+}
+
+func (m *wildcardBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if supported, isResolver := m.supported[*call.To]; isResolver {
+		switch string(call.Data[:4]) {
+		case string(erc165InterfaceID[:]):
+			var id [4]byte
+			copy(id[:], call.Data[4:8])
+			if supported[id] {
+				return abiWords(1), nil
+			}
+			return abiWords(0), nil
+		case string(resolveSelector):
+			values, err := resolveArgs.Unpack(call.Data[4:])
+			if err != nil {
+				return nil, err
+			}
+			m.name = values[0].([]byte)
+			m.data = values[1].([]byte)
+			return abiBytes(abiBytes(m.contenthash)), nil
+		}
+		return nil, revertError{}
+	}
+	// A call to the registry, for the owner or resolver of a node.
+	for domain, resolver := range m.resolvers {
+		nameHash, _ := ens.NameHash(domain)
+		if string(call.Data[4:36]) == string(nameHash[:]) {
+			return abiWords(resolver), nil
+		}
+	}
+	return abiWords(ens.UnknownAddress), nil
+}
+
+func TestExtendedResolver(t *testing.T) {
+	extended := common.HexToAddress("0x2eA5C6c4b3bB2A5C3b7e0a4B9e3A5d6b2C1f0E9d")
+	plain := common.HexToAddress("0x3fB6d7D5c4cC3B6d4C8f1B5cAf4B6e7C3d2A1fAe")
+	contenthash := []byte{0xe3, 0x01, 0x01, 0x70}
+	backend := &wildcardBackend{
+		resolvers: map[string]common.Address{
+			"wild.test.eth":  extended,
+			"plain.test.eth": plain,
+		},
+		supported: map[common.Address]map[[4]byte]bool{
+			extended: {erc165InterfaceID: true, {0x90, 0x61, 0xb9, 0x23}: true},
+			plain:    {erc165InterfaceID: true, {0xbc, 0x1c, 0x58, 0xd1}: true},
+		},
+		contenthash: contenthash,
+	}
+	registry, err := ens.NewRegistry(backend)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	e := ENS{Backend: backend, Registry: registry, Zones: []string{"eth."}}
+
+	// A name without a resolver is resolved by the extended resolver of its
+	// closest ancestor with a resolver.
+	info, err := e.resolverInfo(context.Background(), "www.sub.wild.test.eth")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info.parent != "wild.test.eth" || info.address != extended || info.capabilities != extendedCapabilities {
+		t.Fatalf("Unexpected resolver information %+v", info)
+	}
+	res, err := e.obtainContentHash(context.Background(), "www.sub.wild.test.eth.", "www.sub.wild.test.eth.")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(res) != string(contenthash) {
+		t.Fatalf("Unexpected contenthash %x", res)
+	}
+	nameHash, _ := ens.NameHash("www.sub.wild.test.eth")
+	if string(backend.name) != string(ens.DNSWireFormat("www.sub.wild.test.eth")) || string(backend.data[4:36]) != string(nameHash[:]) {
+		t.Fatalf("Unexpected call resolve(%x, %x)", backend.name, backend.data)
+	}
+	if !e.IsAuthoritative(context.Background(), "www.sub.wild.test.eth.") {
+		t.Fatalf("Not authoritative for name with an extended resolver")
+	}
+
+	// Other resolvers are not used for names without a resolver.
+	if _, err := e.resolverInfo(context.Background(), "www.plain.test.eth"); err != errNoResolver {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if e.IsAuthoritative(context.Background(), "www.plain.test.eth.") {
+		t.Fatalf("Authoritative for name without a resolver")
+	}
+}
@@ -0,0 +1,90 @@
+package ens
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	ens "github.com/wealdtech/go-ens/v3"
+)
+
+// The functions in this file make contract calls on behalf of a request,
+// passing the request's context through to the Ethereum node.
+
+// callOpts returns the options for a contract call made with the given context.
+func callOpts(ctx context.Context) *bind.CallOpts {
+	return &bind.CallOpts{Context: ctx}
+}
+
+// owner obtains the owner of a domain from the registry.
+func (e ENS) owner(ctx context.Context, domain string) (common.Address, error) {
+	nameHash, err := ens.NameHash(domain)
+	if err != nil {
+		return ens.UnknownAddress, err
+	}
+	return e.Registry.Contract.Owner(callOpts(ctx), nameHash)
+}
+
+// resolverAddress obtains the address of the resolver of a domain from the registry.
+func (e ENS) resolverAddress(ctx context.Context, domain string) (common.Address, error) {
+	nameHash, err := ens.NameHash(domain)
+	if err != nil {
+		return ens.UnknownAddress, err
+	}
+	return e.Registry.Contract.Resolver(callOpts(ctx), nameHash)
+}
+
+// contenthash obtains the contenthash of a domain from its resolver.
+func contenthash(ctx context.Context, resolver *ens.Resolver, domain string) ([]byte, error) {
+	nameHash, err := ens.NameHash(domain)
+	if err != nil {
+		return nil, err
+	}
+	return resolver.Contract.Contenthash(callOpts(ctx), nameHash)
+}
+
+// ethAddress obtains the Ethereum address of a domain from its resolver.
+func ethAddress(ctx context.Context, resolver *ens.Resolver, domain string) (common.Address, error) {
+	nameHash, err := ens.NameHash(domain)
+	if err != nil {
+		return ens.UnknownAddress, err
+	}
+	return resolver.Contract.Addr(callOpts(ctx), nameHash)
+}
+
+// multiAddress obtains the address of a domain for a coin type from its resolver.
+func multiAddress(ctx context.Context, resolver *ens.Resolver, domain string, coinType uint64) ([]byte, error) {
+	nameHash, err := ens.NameHash(domain)
+	if err != nil {
+		return nil, err
+	}
+	return resolver.Contract.Addr0(callOpts(ctx), nameHash, new(big.Int).SetUint64(coinType))
+}
+
+// text obtains a text record of a domain from its resolver.
+func text(ctx context.Context, resolver *ens.Resolver, domain string, key string) (string, error) {
+	nameHash, err := ens.NameHash(domain)
+	if err != nil {
+		return "", err
+	}
+	return resolver.Contract.Text(callOpts(ctx), nameHash, key)
+}
+
+// dnsRecord obtains the wire-format RRset of a name within a domain from its DNS resolver.
+func dnsRecord(ctx context.Context, resolver *ens.DNSResolver, domain string, name string, qtype uint16) ([]byte, error) {
+	nameHash, err := ens.NameHash(domain)
+	if err != nil {
+		return nil, err
+	}
+	return resolver.Contract.DnsRecord(callOpts(ctx), nameHash, ens.DNSWireFormatDomainHash(name), qtype)
+}
+
+// hasDNSRecords checks if a name within a domain has any RRsets in its DNS resolver.
+func hasDNSRecords(ctx context.Context, resolver *ens.DNSResolver, domain string, name string) (bool, error) {
+	nameHash, err := ens.NameHash(domain)
+	if err != nil {
+		return false, err
+	}
+	return resolver.Contract.HasDNSRecords(callOpts(ctx), nameHash, ens.DNSWireFormatDomainHash(name))
+}
@@ -0,0 +1,70 @@
+package ens
+
+import (
+	"context"
+	"fmt"
+)
+
+// maxChaseDepth is the maximum number of names visited when following CNAME,
+// DNAME and wildcard records to answer a single request.
+const maxChaseDepth = 16
+
+type chaseKey struct{}
+
+// chase tracks the names visited when following CNAME, DNAME and wildcard
+// records to answer a single request.
+type chase struct {
+	visited map[string]bool
+	// check, if set, returns the reason that a name is blocked, if it is.
+	check func(name string) (string, error)
+	// err is the reason the chase was stopped, if any.
+	err error
+}
+
+// blockedError is the reason a chase was stopped at a blocked name.
+type blockedError struct {
+	name   string
+	reason string
+}
+
+func (e *blockedError) Error() string {
+	return fmt.Sprintf("%s is blocked: %s", e.name, e.reason)
+}
+
+func newChase() *chase {
+	return &chase{visited: make(map[string]bool)}
+}
+
+// withChase attaches a chase to the context.
+func withChase(ctx context.Context, c *chase) context.Context {
+	return context.WithValue(ctx, chaseKey{}, c)
+}
+
+// chaseFrom obtains the chase from the context, or nil if there isn't one.
+func chaseFrom(ctx context.Context) *chase {
+	c, _ := ctx.Value(chaseKey{}).(*chase)
+	return c
+}
+
+// visit records a visit to a name, returning an error if the name has
+// already been visited, too many names have been visited, or the name is
+// blocked.
+func (c *chase) visit(name string) error {
+	switch {
+	case c.visited[name]:
+		c.err = fmt.Errorf("loop detected at %s", name)
+	case len(c.visited) >= maxChaseDepth:
+		c.err = fmt.Errorf("more than %d names followed at %s", maxChaseDepth, name)
+	default:
+		c.visited[name] = true
+		if c.check != nil {
+			reason, err := c.check(name)
+			if err != nil {
+				c.err = fmt.Errorf("failed to check %s against the blocklist: %v", name, err)
+			} else if reason != "" {
+				c.err = &blockedError{name: name, reason: reason}
+			}
+		}
+	}
+	return c.err
+}
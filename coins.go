@@ -0,0 +1,203 @@
+package ens
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/mr-tron/base58"
+)
+
+// evmCoinTypeFlag is set on coin types that represent EVM chains, as per
+// ENSIP-11.  The remaining bits of the coin type are the chain ID.
+const evmCoinTypeFlag = 0x80000000
+
+// coin defines the presentation of an ENSIP-9 address for a coin type.
+type coin struct {
+	// name is the name of the coin, used in the addr.<name>= TXT record.
+	name string
+	// encode turns the binary address in to its native textual form.
+	encode func(data []byte) (string, error)
+}
+
+// coins are the coin types with known encodings, keyed by SLIP-44 coin type.
+var coins = map[uint64]*coin{
+	0:   {name: "btc", encode: bitcoinEncoder(0x00, 0x05, "bc")},
+	2:   {name: "ltc", encode: bitcoinEncoder(0x30, 0x32, "ltc")},
+	3:   {name: "doge", encode: bitcoinEncoder(0x1e, 0x16, "")},
+	60:  {name: "eth", encode: encodeEVMAddress},
+	61:  {name: "etc", encode: encodeEVMAddress},
+	501: {name: "sol", encode: encodeSolanaAddress},
+
+	evmCoinTypeFlag | 10:    {name: "op", encode: encodeEVMAddress},
+	evmCoinTypeFlag | 56:    {name: "bsc", encode: encodeEVMAddress},
+	evmCoinTypeFlag | 100:   {name: "gno", encode: encodeEVMAddress},
+	evmCoinTypeFlag | 137:   {name: "matic", encode: encodeEVMAddress},
+	evmCoinTypeFlag | 8453:  {name: "base", encode: encodeEVMAddress},
+	evmCoinTypeFlag | 42161: {name: "arb1", encode: encodeEVMAddress},
+}
+
+// coinType obtains the coin type given either its name or its number.
+// Any EVM chain can be referenced by its ENSIP-11 coin type.
+func coinType(input string) (uint64, error) {
+	for id, coin := range coins {
+		if strings.EqualFold(coin.name, input) {
+			return id, nil
+		}
+	}
+	id, err := strconv.ParseUint(input, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("unknown coin %s", input)
+	}
+	if _, exists := coins[id]; !exists && id&evmCoinTypeFlag == 0 {
+		return 0, fmt.Errorf("unsupported coin type %d", id)
+	}
+	return id, nil
+}
+
+// coinName returns the name of the coin type for use in TXT records.
+func coinName(id uint64) string {
+	if coin, exists := coins[id]; exists {
+		return coin.name
+	}
+	return fmt.Sprintf("evm.%d", id&^evmCoinTypeFlag)
+}
+
+// encodeAddress turns the binary address for a coin type in to its native
+// textual form.
+func encodeAddress(id uint64, data []byte) (string, error) {
+	if coin, exists := coins[id]; exists {
+		return coin.encode(data)
+	}
+	if id&evmCoinTypeFlag != 0 {
+		return encodeEVMAddress(data)
+	}
+	return "", fmt.Errorf("unsupported coin type %d", id)
+}
+
+// encodeEVMAddress encodes an address as a checksummed hex string.
+func encodeEVMAddress(data []byte) (string, error) {
+	if len(data) != common.AddressLength {
+		return "", errors.New("invalid EVM address length")
+	}
+	return common.BytesToAddress(data).Hex(), nil
+}
+
+// encodeSolanaAddress encodes an address as base58.
+func encodeSolanaAddress(data []byte) (string, error) {
+	if len(data) != 32 {
+		return "", errors.New("invalid Solana address length")
+	}
+	return base58.Encode(data), nil
+}
+
+// bitcoinEncoder returns an encoder for Bitcoin-style addresses, which are
+// stored as the scriptPubkey that pays to the address.
+func bitcoinEncoder(p2pkhVersion byte, p2shVersion byte, hrp string) func([]byte) (string, error) {
+	return func(data []byte) (string, error) {
+		switch {
+		case len(data) == 25 && data[0] == 0x76 && data[1] == 0xa9 && data[2] == 0x14 && data[23] == 0x88 && data[24] == 0xac:
+			// P2PKH.
+			return base58CheckEncode(p2pkhVersion, data[3:23]), nil
+		case len(data) == 23 && data[0] == 0xa9 && data[1] == 0x14 && data[22] == 0x87:
+			// P2SH.
+			return base58CheckEncode(p2shVersion, data[2:22]), nil
+		case hrp != "" && len(data) >= 4 && (data[0] == 0x00 || (data[0] >= 0x51 && data[0] <= 0x60)) && int(data[1]) == len(data)-2:
+			// Segwit.
+			version := data[0]
+			if version != 0x00 {
+				version -= 0x50
+			}
+			return segwitEncode(hrp, version, data[2:])
+		default:
+			return "", errors.New("unrecognised scriptPubkey")
+		}
+	}
+}
+
+// base58CheckEncode encodes a versioned payload with a checksum in base58.
+func base58CheckEncode(version byte, payload []byte) string {
+	data := append([]byte{version}, payload...)
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return base58.Encode(append(data, second[:4]...))
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// segwitEncode encodes a witness program as bech32 (version 0) or bech32m
+// (version 1 onwards), as per BIP-173 and BIP-350.
+func segwitEncode(hrp string, version byte, program []byte) (string, error) {
+	if len(program) < 2 || len(program) > 40 {
+		return "", errors.New("invalid witness program length")
+	}
+	data := append([]byte{version}, convertBits(program, 8, 5)...)
+
+	constant := uint32(1)
+	if version != 0 {
+		constant = 0x2bc830a3
+	}
+	values := append(bech32HRPExpand(hrp), data...)
+	polymod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ constant
+	for i := 0; i < 6; i++ {
+		data = append(data, byte((polymod>>uint(5*(5-i)))&31))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, b := range data {
+		sb.WriteByte(bech32Charset[b])
+	}
+	return sb.String(), nil
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	res := make([]byte, 0, len(hrp)*2+1)
+	for i := range hrp {
+		res = append(res, hrp[i]>>5)
+	}
+	res = append(res, 0)
+	for i := range hrp {
+		res = append(res, hrp[i]&31)
+	}
+	return res
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// convertBits regroups data from one bit width to another, padding as required.
+func convertBits(data []byte, fromBits uint, toBits uint) []byte {
+	acc := uint32(0)
+	bits := uint(0)
+	maxv := uint32(1)<<toBits - 1
+	res := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, value := range data {
+		acc = acc<<fromBits | uint32(value)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			res = append(res, byte((acc>>bits)&maxv))
+		}
+	}
+	if bits > 0 {
+		res = append(res, byte((acc<<(toBits-bits))&maxv))
+	}
+	return res
+}
@@ -0,0 +1,145 @@
+package ens
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/miekg/dns"
+	ens "github.com/wealdtech/go-ens/v3"
+)
+
+func TestEncodeAddress(t *testing.T) {
+	tests := []struct {
+		coin    uint64
+		data    string
+		address string
+		err     bool
+	}{
+		{0, "76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac", "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", false},
+		{0, "a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1887", "3Ai1JZ8pdJb2ksieUV8FsxSNVJCpoPi8W6", false},
+		{0, "0014751e76e8199196d454941c45d1b3a323f1433bd6", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", false},
+		{0, "5128751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6", "bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y", false},
+		{0, "0102", "", true},
+		{3, "0014751e76e8199196d454941c45d1b3a323f1433bd6", "", true},
+		{60, "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", false},
+		{60, "5aaeb6053f3e94c9b9a09f33669435e7ef1bea", "", true},
+		{evmCoinTypeFlag | 10, "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", false},
+		{evmCoinTypeFlag | 12345, "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", false},
+		{501, "0000000000000000000000000000000000000000000000000000000000000000", "11111111111111111111111111111111", false},
+		{9999, "00", "", true},
+	}
+
+	for i, tt := range tests {
+		data, err := hex.DecodeString(tt.data)
+		if err != nil {
+			t.Fatalf("Test %d bad data: %v", i, err)
+		}
+		address, err := encodeAddress(tt.coin, data)
+		if tt.err {
+			if err == nil {
+				t.Errorf("Test %d expected error, got %s", i, address)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d unexpected error: %v", i, err)
+		} else if address != tt.address {
+			t.Errorf("Test %d: %s (expected %s)", i, address, tt.address)
+		}
+	}
+}
+
+func TestCoinType(t *testing.T) {
+	tests := []struct {
+		input string
+		id    uint64
+		name  string
+		err   bool
+	}{
+		{"btc", 0, "btc", false},
+		{"LTC", 2, "ltc", false},
+		{"60", 60, "eth", false},
+		{"op", evmCoinTypeFlag | 10, "op", false},
+		{"2147483658", evmCoinTypeFlag | 10, "op", false},
+		{"0x80003039", evmCoinTypeFlag | 12345, "evm.12345", false},
+		{"9999", 0, "", true},
+		{"unknown", 0, "", true},
+	}
+
+	for i, tt := range tests {
+		id, err := coinType(tt.input)
+		if tt.err {
+			if err == nil {
+				t.Errorf("Test %d expected error, got %d", i, id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d unexpected error: %v", i, err)
+			continue
+		}
+		if id != tt.id || coinName(id) != tt.name {
+			t.Errorf("Test %d: %d/%s (expected %d/%s)", i, id, coinName(id), tt.id, tt.name)
+		}
+	}
+}
+
+func TestAddressRecordsWithoutContenthash(t *testing.T) {
+	btc, _ := hex.DecodeString("76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac")
+	backend := &methodBackend{results: map[string][]byte{
+		"resolver(bytes32)":                 abiWords(common.HexToAddress("0x5FfC014343cd971B7eb70732021E26C35B744cc4")),
+		"supportsInterface(bytes4)":         abiWords(1),
+		"contenthash(bytes32)":              abiBytes(nil),
+		"dnsRecord(bytes32,bytes32,uint16)": abiBytes(nil),
+		"addr(bytes32)":                     abiWords(common.HexToAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")),
+		"addr(bytes32,uint256)":             abiBytes(btc),
+	}}
+	registry, err := ens.NewRegistry(backend)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	e := ENS{Backend: backend, Registry: registry, Coins: []uint64{0}}
+
+	results, err := e.Query(context.Background(), "payments.test.eth.", "payments.test.eth.", dns.TypeTXT, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Unexpected records %v", results)
+	}
+	if txt := results[0].(*dns.TXT).Txt[0]; txt != "a=0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed" {
+		t.Fatalf("Unexpected record %s", txt)
+	}
+	if txt := results[1].(*dns.TXT).Txt[0]; txt != "addr.btc=1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa" {
+		t.Fatalf("Unexpected record %s", txt)
+	}
+}
+
+func TestAddressRecordsWithoutDNSRecords(t *testing.T) {
+	// The resolver returns an invalid result for DNS records.
+	backend := &methodBackend{results: map[string][]byte{
+		"resolver(bytes32)":                 abiWords(common.HexToAddress("0x6c8D9f7E6a5B4c3D2e1F0a9B8c7D6e5F4a3B2c1D")),
+		"supportsInterface(bytes4)":         abiWords(1),
+		"contenthash(bytes32)":              abiBytes(nil),
+		"dnsRecord(bytes32,bytes32,uint16)": {0x01},
+		"addr(bytes32)":                     abiWords(common.HexToAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")),
+	}}
+	registry, err := ens.NewRegistry(backend)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	e := ENS{Backend: backend, Registry: registry}
+
+	results, err := e.Query(context.Background(), "nodns.test.eth.", "nodns.test.eth.", dns.TypeTXT, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Unexpected records %v", results)
+	}
+	if txt := results[0].(*dns.TXT).Txt[0]; txt != "a=0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed" {
+		t.Fatalf("Unexpected record %s", txt)
+	}
+}
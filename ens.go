@@ -4,16 +4,16 @@ package ens
 import (
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/fall"
 	"github.com/coredns/coredns/request"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/ethclient"
-	lru "github.com/hashicorp/golang-lru"
-	"github.com/labstack/gommon/log"
 	ens "github.com/wealdtech/go-ens/v3"
 
 	"github.com/miekg/dns"
@@ -25,49 +25,113 @@ var emptyContentHash = []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x
 type ENS struct {
 	Next               plugin.Handler
 	Client             *ethclient.Client
+	Backend            bind.ContractBackend
 	Registry           *ens.Registry
 	EthLinkNameServers []string
-	IPFSGatewayAs      []string
-	IPFSGatewayAAAAs   []string
+	GatewayAs          map[string][]string
+	GatewayAAAAs       map[string][]string
+	Gateways           *gatewayChecker
+	GatewaySelector    *gatewaySelector
+	GatewayBalancer    *gatewayBalancer
+	TTLs               map[uint16]uint32
+	TTLRecord          string
+	CAAs               []dns.CAA
+	Challenges         *challengeStore
+	SubdomainGateway   string
+	HTTPSALPN          []string
+	HTTPSPort          uint16
+	HTTPSHints         bool
+	TextRecords        []string
+	Coins              []uint64
+	SuffixMappings     []suffixMapping
+	Zones              []string
+	Fall               fall.F
+	QueryLog           bool
+	Monitor            *healthMonitor
+	LookupTimeout      time.Duration
+	Policy             *policy
+	Expiry             *expiryChecker
 }
 
-// IsAuthoritative checks if the ENS plugin is authoritative for a given domain
-func (e ENS) IsAuthoritative(domain string) bool {
-	controllerAddress, err := e.Registry.Owner(strings.TrimSuffix(domain, "."))
+// IsAuthoritative checks if the ENS plugin is authoritative for a given domain,
+// which requires it to have an owner and not to have expired, or to be
+// resolved by the extended resolver of an ancestor for which the plugin is
+// authoritative.
+func (e ENS) IsAuthoritative(ctx context.Context, domain string) bool {
+	if !e.inZones(domain) {
+		return false
+	}
+
+	span, ctx := startSpan(ctx, "ens.IsAuthoritative")
+	defer span.Finish()
+	span.SetTag("domain", domain)
+
+	controllerAddress, err := e.owner(ctx, strings.TrimSuffix(domain, "."))
 	if err != nil {
 		return false
 	}
 
-	return controllerAddress != ens.UnknownAddress
+	if controllerAddress == ens.UnknownAddress {
+		info, err := e.resolverInfo(ctx, strings.TrimSuffix(domain, "."))
+		if err != nil || info.parent == "" {
+			return false
+		}
+		return e.IsAuthoritative(ctx, info.parent+".")
+	}
+
+	return !e.expired(ctx, domain, controllerAddress)
 }
 
 // HasRecords checks if there are any records for a specific domain and name.
 // This is used for wildcard eligibility
-func (e ENS) HasRecords(domain string, name string) (bool, error) {
-	// See if this has a contenthash record.
-	resolver, err := e.getResolver(domain)
-	if err != nil {
-		return false, err
+func (e ENS) HasRecords(ctx context.Context, domain string, name string) (bool, error) {
+	// Text record requests are synthesized, so always present.
+	if e.textRecordKeys(name, domain) != nil {
+		return true, nil
 	}
-	bytes, err := resolver.Contenthash()
-	if err == nil && len(bytes) > 0 {
-		return true, err
+
+	span, ctx := startSpan(ctx, "ens.HasRecords")
+	defer span.Finish()
+	span.SetTag("domain", domain)
+	span.SetTag("name", name)
+
+	// See if this has a contenthash record.
+	ethDomain := strings.TrimSuffix(domain, ".")
+	if bytes, err := e.obtainContentHash(ctx, name, domain); err == nil && len(bytes) > 0 {
+		return true, nil
 	}
 
 	// See if this has DNS records.
-	dnsResolver, err := e.getDNSResolver(strings.TrimSuffix(domain, "."))
+	dnsResolver, err := e.getDNSResolver(ctx, ethDomain)
 	if err != nil {
 		return false, err
 	}
-	return dnsResolver.HasRecords(name)
+	return hasDNSRecords(ctx, dnsResolver, ethDomain, name)
 }
 
 // Query queries a given domain/name/resource combination
-func (e ENS) Query(domain string, name string, qtype uint16, do bool) ([]dns.RR, error) {
+func (e ENS) Query(ctx context.Context, domain string, name string, qtype uint16, do bool) ([]dns.RR, error) {
 	log.Debugf("request type %d for name %s in domain %v", qtype, name, domain)
 
+	span, ctx := startSpan(ctx, "ens.Query")
+	defer span.Finish()
+	span.SetTag("domain", domain)
+	span.SetTag("name", name)
+	span.SetTag("type", dns.TypeToString[qtype])
+
+	// The TTL text record applies to every record synthesized for the query,
+	// so it is read once.
+	ctx = e.withTTLOverride(ctx, domain)
+
 	results := make([]dns.RR, 0)
 
+	if qtype == dns.TypeTXT {
+		if keys := e.textRecordKeys(name, domain); keys != nil {
+			recordPath(ctx, qtype, "text")
+			return e.handleTextRecords(ctx, name, domain, keys)
+		}
+	}
+
 	// If the requested domain has a content hash we alter a number of the records returned
 	var contentHash []byte
 	hasContentHash := false
@@ -76,56 +140,77 @@ func (e ENS) Query(domain string, name string, qtype uint16, do bool) ([]dns.RR,
 		qtype == dns.TypeNS ||
 		qtype == dns.TypeTXT ||
 		qtype == dns.TypeA ||
-		qtype == dns.TypeAAAA {
-		contentHash, err = e.obtainContentHash(name, domain)
+		qtype == dns.TypeAAAA ||
+		qtype == dns.TypeHTTPS ||
+		qtype == dns.TypeSVCB ||
+		qtype == dns.TypeCAA {
+		contentHash, err = e.obtainContentHash(ctx, name, domain)
 		hasContentHash = err == nil && bytes.Compare(contentHash, emptyContentHash) > 0
 	}
 	if hasContentHash {
+		recordPath(ctx, qtype, "contenthash")
 		switch qtype {
 		case dns.TypeSOA:
-			results, err = e.handleSOA(name, domain, contentHash)
+			results, err = e.handleSOA(ctx, name, domain, contentHash)
 		case dns.TypeNS:
-			results, err = e.handleNS(name, domain, contentHash)
+			results, err = e.handleNS(ctx, name, domain, contentHash)
 		case dns.TypeTXT:
-			results, err = e.handleTXT(name, domain, contentHash)
+			results, err = e.handleTXT(ctx, name, domain, contentHash)
 		case dns.TypeA:
-			results, err = e.handleA(name, domain, contentHash)
+			results, err = e.handleA(ctx, name, domain, contentHash)
 		case dns.TypeAAAA:
-			results, err = e.handleAAAA(name, domain, contentHash)
+			results, err = e.handleAAAA(ctx, name, domain, contentHash)
+		case dns.TypeHTTPS, dns.TypeSVCB:
+			results, err = e.handleHTTPS(ctx, name, domain, contentHash, qtype)
+		case dns.TypeCAA:
+			results, err = e.handleCAA(ctx, name, domain, contentHash)
 		}
 	} else {
+		recordPath(ctx, qtype, "dnsresolver")
 		ethDomain := strings.TrimSuffix(domain, ".")
-		resolver, err := e.getDNSResolver(ethDomain)
+		addressRRs := make([]dns.RR, 0)
+		if qtype == dns.TypeTXT && isRealOnChainDomain(name, domain) {
+			if info, err := e.resolverInfo(ctx, ethDomain); err == nil {
+				addressRRs, err = e.handleAddresses(ctx, name, domain, info)
+				if err != nil {
+					return results, err
+				}
+			}
+		}
+		// Address records are returned even if DNS records cannot be
+		// obtained.  Calls to the Ethereum node that could not be made fail
+		// the request regardless, so errors here are resolvers without DNS
+		// records.
+		resolver, err := e.getDNSResolver(ctx, ethDomain)
 		if err != nil {
-			return results, nil
+			return addressRRs, nil
 		}
+		queryLogEntryFrom(ctx).addResolver(resolver.ContractAddr.Hex())
 
-		data, err := resolver.Record(name, qtype)
+		data, err := dnsRecord(ctx, resolver, ethDomain, name, qtype)
 		if err != nil {
-			return results, err
+			log.Debugf("failed to obtain %s records for %s: %v", dns.TypeToString[qtype], name, err)
+			return addressRRs, nil
 		}
 
-		offset := 0
-		for offset < len(data) {
-			var result dns.RR
-			result, offset, err = dns.UnpackRR(data, offset)
-			if err == nil {
-				results = append(results, result)
-			}
+		results, err = unpackRRs(data)
+		if err != nil {
+			log.Warningf("invalid %s records for %s: %v", dns.TypeToString[qtype], name, err)
 		}
+		results = append(inBailiwick(results, name, qtype), addressRRs...)
 	}
 
 	return results, nil
 }
 
-func (e ENS) handleSOA(name string, domain string, contentHash []byte) ([]dns.RR, error) {
+func (e ENS) handleSOA(ctx context.Context, name string, domain string, contentHash []byte) ([]dns.RR, error) {
 	results := make([]dns.RR, 0)
 	if len(e.EthLinkNameServers) > 0 {
 		// Create a synthetic SOA record
 		now := time.Now()
 		ser := ((now.Hour()*3600 + now.Minute()) * 100) / 86400
 		dateStr := fmt.Sprintf("%04d%02d%02d%02d", now.Year(), now.Month(), now.Day(), ser)
-		result, err := dns.NewRR(fmt.Sprintf("%s 10800 IN SOA %s hostmaster.%s %s 3600 600 1209600 300", e.EthLinkNameServers[0], name, name, dateStr))
+		result, err := dns.NewRR(fmt.Sprintf("%s %d IN SOA %s hostmaster.%s %s 3600 600 1209600 300", e.EthLinkNameServers[0], e.ttl(ctx, domain, dns.TypeSOA), name, name, dateStr))
 		if err != nil {
 			return results, err
 		}
@@ -134,10 +219,11 @@ func (e ENS) handleSOA(name string, domain string, contentHash []byte) ([]dns.RR
 	return results, nil
 }
 
-func (e ENS) handleNS(name string, domain string, contentHash []byte) ([]dns.RR, error) {
+func (e ENS) handleNS(ctx context.Context, name string, domain string, contentHash []byte) ([]dns.RR, error) {
 	results := make([]dns.RR, 0)
+	ttl := e.ttl(ctx, domain, dns.TypeNS)
 	for _, nameserver := range e.EthLinkNameServers {
-		result, err := dns.NewRR(fmt.Sprintf("%s 3600 IN NS %s", domain, nameserver))
+		result, err := dns.NewRR(fmt.Sprintf("%s %d IN NS %s", domain, ttl, nameserver))
 		if err != nil {
 			return results, err
 		}
@@ -147,46 +233,34 @@ func (e ENS) handleNS(name string, domain string, contentHash []byte) ([]dns.RR,
 	return results, nil
 }
 
-func (e ENS) handleTXT(name string, domain string, contentHash []byte) ([]dns.RR, error) {
+func (e ENS) handleTXT(ctx context.Context, name string, domain string, contentHash []byte) ([]dns.RR, error) {
 	results := make([]dns.RR, 0)
-	txtRRSet, err := e.obtainTXTRRSet(name, domain)
+	txtRRSet, err := e.obtainTXTRRSet(ctx, name, domain)
 	if err == nil && len(txtRRSet) != 0 {
 		// We have a TXT rrset; use it
-		offset := 0
-		for offset < len(txtRRSet) {
-			var result dns.RR
-			result, offset, err = dns.UnpackRR(txtRRSet, offset)
-			if err == nil {
-				results = append(results, result)
-			}
+		results, err = unpackRRs(txtRRSet)
+		if err != nil {
+			log.Warningf("invalid TXT records for %s: %v", name, err)
 		}
+		results = inBailiwick(results, name, dns.TypeTXT)
 	}
 
 	if isRealOnChainDomain(name, domain) {
+		ttl := e.ttl(ctx, domain, dns.TypeTXT)
 		ethDomain := strings.TrimSuffix(domain, ".")
-		resolver, err := e.getResolver(ethDomain)
+		info, err := e.resolverInfo(ctx, ethDomain)
 		if err != nil {
-			log.Warnf("error obtaining resolver for %s: %v", ethDomain, err)
+			log.Warningf("error obtaining resolver for %s: %v", ethDomain, err)
 			return results, nil
 		}
 
-		address, err := resolver.Address()
+		addressRRs, err := e.handleAddresses(ctx, name, domain, info)
 		if err != nil {
-			if err.Error() != "abi: unmarshalling empty output" {
-				return results, err
-			}
-			return results, nil
-		}
-
-		if address != ens.UnknownAddress {
-			result, err := dns.NewRR(fmt.Sprintf("%s 3600 IN TXT \"a=%s\"", name, address.Hex()))
-			if err != nil {
-				return results, err
-			}
-			results = append(results, result)
+			return results, err
 		}
+		results = append(results, addressRRs...)
 
-		result, err := dns.NewRR(fmt.Sprintf("%s 3600 IN TXT \"contenthash=0x%x\"", name, contentHash))
+		result, err := dns.NewRR(fmt.Sprintf("%s %d IN TXT \"contenthash=0x%x\"", name, ttl, contentHash))
 		if err != nil {
 			return results, err
 		}
@@ -197,7 +271,7 @@ func (e ENS) handleTXT(name string, domain string, contentHash []byte) ([]dns.RR
 		if err != nil {
 			return results, err
 		}
-		result, err = dns.NewRR(fmt.Sprintf("%s 3600 IN TXT \"dnslink=%s\"", name, contentHashStr))
+		result, err = dns.NewRR(fmt.Sprintf("%s %d IN TXT \"dnslink=%s\"", name, ttl, contentHashStr))
 		if err != nil {
 			return results, nil
 		}
@@ -208,7 +282,7 @@ func (e ENS) handleTXT(name string, domain string, contentHash []byte) ([]dns.RR
 		if err != nil {
 			return results, err
 		}
-		result, err := dns.NewRR(fmt.Sprintf("%s 3600 IN TXT \"dnslink=%s\"", name, contentHashStr))
+		result, err := dns.NewRR(fmt.Sprintf("%s %d IN TXT \"dnslink=%s\"", name, e.ttl(ctx, domain, dns.TypeTXT), contentHashStr))
 		if err != nil {
 			return results, err
 		}
@@ -218,24 +292,73 @@ func (e ENS) handleTXT(name string, domain string, contentHash []byte) ([]dns.RR
 	return results, nil
 }
 
-func (e ENS) handleA(name string, domain string, contentHash []byte) ([]dns.RR, error) {
+// handleAddresses returns the TXT records for the Ethereum address and the
+// configured multi-coin addresses of a domain.  These are returned whether or
+// not the domain has a contenthash.
+func (e ENS) handleAddresses(ctx context.Context, name string, domain string, info *resolverInfo) ([]dns.RR, error) {
+	results := make([]dns.RR, 0)
+	ttl := e.ttl(ctx, domain, dns.TypeTXT)
+	ethDomain := strings.TrimSuffix(domain, ".")
+
+	if info.capabilities.supports(capAddr) {
+		address, err := ethAddress(ctx, info.resolver, ethDomain)
+		if err != nil {
+			return results, err
+		}
+		if address != ens.UnknownAddress {
+			result, err := dns.NewRR(fmt.Sprintf("%s %d IN TXT \"a=%s\"", name, ttl, address.Hex()))
+			if err != nil {
+				return results, err
+			}
+			results = append(results, result)
+		}
+	}
+
+	if info.capabilities.supports(capMulticoin) {
+		for _, coin := range e.Coins {
+			data, err := multiAddress(ctx, info.resolver, ethDomain, coin)
+			if err != nil || len(data) == 0 {
+				continue
+			}
+			address, err := encodeAddress(coin, data)
+			if err != nil {
+				log.Warningf("error encoding %s address for %s: %v", coinName(coin), ethDomain, err)
+				continue
+			}
+			result, err := dns.NewRR(fmt.Sprintf("%s %d IN TXT \"addr.%s=%s\"", name, ttl, coinName(coin), address))
+			if err != nil {
+				return results, err
+			}
+			results = append(results, result)
+		}
+	}
+
+	return results, nil
+}
+
+func (e ENS) handleA(ctx context.Context, name string, domain string, contentHash []byte) ([]dns.RR, error) {
 	results := make([]dns.RR, 0)
 
-	aRRSet, err := e.obtainARRSet(name, domain)
+	aRRSet, err := e.obtainARRSet(ctx, name, domain)
 	if err == nil && len(aRRSet) != 0 {
 		// We have an A rrset; use it
-		offset := 0
-		for offset < len(aRRSet) {
-			var result dns.RR
-			result, offset, err = dns.UnpackRR(aRRSet, offset)
-			if err == nil {
-				results = append(results, result)
-			}
+		results, err = unpackRRs(aRRSet)
+		if err != nil {
+			log.Warningf("invalid A records for %s: %v", name, err)
 		}
+		results = inBailiwick(results, name, dns.TypeA)
+	} else if cname := e.gatewayCNAME(ctx, name, domain, contentHash); cname != nil {
+		// We have a content hash served by a subdomain gateway; point to it
+		results = append(results, cname)
 	} else {
-		// We have a content hash but no A record; use the default
-		for i := range e.IPFSGatewayAs {
-			result, err := dns.NewRR(fmt.Sprintf("%s 3600 IN A %s", name, e.IPFSGatewayAs[i]))
+		// We have a content hash but no A record; use the gateways for its codec
+		codec, err := contenthashCodec(contentHash)
+		if err != nil {
+			return results, nil
+		}
+		ttl := e.ttl(ctx, domain, dns.TypeA)
+		for _, address := range e.gatewayAddresses(ctx, codec, dns.TypeA) {
+			result, err := dns.NewRR(fmt.Sprintf("%s %d IN A %s", name, ttl, address))
 			if err != nil {
 				return results, err
 			}
@@ -246,26 +369,32 @@ func (e ENS) handleA(name string, domain string, contentHash []byte) ([]dns.RR,
 	return results, nil
 }
 
-func (e ENS) handleAAAA(name string, domain string, contentHash []byte) ([]dns.RR, error) {
+func (e ENS) handleAAAA(ctx context.Context, name string, domain string, contentHash []byte) ([]dns.RR, error) {
 	results := make([]dns.RR, 0)
 
-	aaaaRRSet, err := e.obtainAAAARRSet(name, domain)
+	aaaaRRSet, err := e.obtainAAAARRSet(ctx, name, domain)
 	if err == nil && len(aaaaRRSet) != 0 {
 		// We have an AAAA rrset; use it
-		offset := 0
-		for offset < len(aaaaRRSet) {
-			var result dns.RR
-			result, offset, err = dns.UnpackRR(aaaaRRSet, offset)
-			if err == nil {
-				results = append(results, result)
-			}
+		results, err = unpackRRs(aaaaRRSet)
+		if err != nil {
+			log.Warningf("invalid AAAA records for %s: %v", name, err)
 		}
+		results = inBailiwick(results, name, dns.TypeAAAA)
+	} else if cname := e.gatewayCNAME(ctx, name, domain, contentHash); cname != nil {
+		// We have a content hash served by a subdomain gateway; point to it
+		results = append(results, cname)
 	} else {
-		// We have a content hash but no AAAA record; use the default
-		for i := range e.IPFSGatewayAAAAs {
-			result, err := dns.NewRR(fmt.Sprintf("%s 3600 IN AAAA %s", name, e.IPFSGatewayAAAAs[i]))
+		// We have a content hash but no AAAA record; use the gateways for its codec
+		codec, err := contenthashCodec(contentHash)
+		if err != nil {
+			return results, nil
+		}
+		ttl := e.ttl(ctx, domain, dns.TypeAAAA)
+		for _, address := range e.gatewayAddresses(ctx, codec, dns.TypeAAAA) {
+			result, err := dns.NewRR(fmt.Sprintf("%s %d IN AAAA %s", name, ttl, address))
 			if err != nil {
-				log.Warnf("error creating %s AAAA RR: %v", name, err)
+				log.Warningf("error creating %s AAAA RR: %v", name, err)
+				continue
 			}
 			results = append(results, result)
 		}
@@ -273,31 +402,159 @@ func (e ENS) handleAAAA(name string, domain string, contentHash []byte) ([]dns.R
 	return results, nil
 }
 
+// inZones checks if an ENS name is within the zones served by the plugin,
+// either directly or when mapped to its DNS suffix.
+func (e ENS) inZones(name string) bool {
+	if plugin.Zones(e.Zones).Matches(name) != "" {
+		return true
+	}
+	for i := range e.SuffixMappings {
+		if dnsName := e.SuffixMappings[i].toDNS(name); dnsName != name && plugin.Zones(e.Zones).Matches(dnsName) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultLookupTimeout is the default maximum time allowed to answer a request.
+const defaultLookupTimeout = 5 * time.Second
+
 // ServeDNS implements the plugin.Handler interface.
 func (e ENS) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
 	state := request.Request{W: w, Req: r}
+	if plugin.Zones(e.Zones).Matches(state.Name()) == "" {
+		return plugin.NextOrFailure(e.Name(), e.Next, ctx, w, r)
+	}
+
+	if e.LookupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.LookupTimeout)
+		defer cancel()
+	}
+
+	chase := newChase()
+	ctx = withChase(ctx, chase)
+	client := newClient(state)
+	ctx = withClient(ctx, client)
+	failure := &rpcFailure{}
+	ctx = withRPCFailure(ctx, failure)
+	path := &requestPath{qtype: state.QType()}
+	ctx = withRequestPath(ctx, path)
+	defer path.finish()
+
+	var entry *queryLogEntry
+	if e.QueryLog {
+		entry = &queryLogEntry{started: time.Now(), QName: state.Name(), QType: state.Type()}
+		ctx = withQueryLogEntry(ctx, entry)
+	}
 
 	a := new(dns.Msg)
 	a.SetReply(r)
 	a.Compress = true
 	a.Authoritative = true
+	ensName := strings.ToLower(state.Name())
+	mapping := e.suffixMappingFor(ensName)
+	if mapping != nil {
+		ensName = mapping.toENS(ensName)
+		if entry != nil {
+			entry.ENSName = ensName
+		}
+	}
+
+	if e.Policy != nil {
+		reason, err := e.Policy.blocked(ctx, e, ensName)
+		if err != nil {
+			// Names that cannot be checked are not answered.
+			log.Warningf("failed to check %s against the blocklist: %v", state.Name(), err)
+			entry.finish(ServerFailure.String())
+			return dns.RcodeServerFailure, nil
+		}
+		if reason != "" {
+			return e.writeBlocked(ctx, state, w, a, client, entry, reason), nil
+		}
+		// Names reached by following CNAME, DNAME and wildcard records are
+		// checked as they are visited.
+		chase.check = func(name string) (string, error) {
+			if !e.inZones(name) {
+				return "", nil
+			}
+			return e.Policy.blocked(ctx, e, name)
+		}
+	}
+
+	if e.Challenges != nil && state.QType() == dns.TypeTXT {
+		if values := e.Challenges.lookup(state.Name()); len(values) > 0 {
+			recordPath(ctx, state.QType(), "acme")
+			e.Challenges.respond(state, a, values)
+			client.setScope(a)
+			entry.finish(Success.String())
+			w.WriteMsg(a)
+			return dns.RcodeSuccess, nil
+		}
+	}
+
 	var result Result
-	a.Answer, a.Ns, a.Extra, result = Lookup(e, state)
+	if mapping != nil {
+		// Translate the query in to the ENS namespace, and the results back out.
+		ensReq := r.Copy()
+		ensReq.Question[0].Name = ensName
+		a.Answer, a.Ns, a.Extra, result = Lookup(ctx, e, request.Request{W: w, Req: ensReq})
+		mapping.toDNSRRs(a.Answer)
+		mapping.toDNSRRs(a.Ns)
+		mapping.toDNSRRs(a.Extra)
+	} else {
+		a.Answer, a.Ns, a.Extra, result = Lookup(ctx, e, state)
+	}
+	if blocked, isBlocked := chase.err.(*blockedError); isBlocked {
+		a.Answer, a.Ns, a.Extra = nil, nil, nil
+		return e.writeBlocked(ctx, state, w, a, client, entry, blocked.Error()), nil
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		// Failed calls may have been treated as missing data, so the result
+		// cannot be trusted.
+		log.Warningf("lookup of %s timed out", state.Name())
+		result = ServerFailure
+	} else if err := failure.failed(); err != nil {
+		// As above, for a single call that failed or timed out.
+		log.Warningf("lookup of %s failed: %v", state.Name(), err)
+		result = ServerFailure
+	}
+	lookupCount.WithLabelValues(metrics.WithServer(ctx), result.String()).Inc()
+	entry.finish(result.String())
 	switch result {
 	case Success:
 		state.SizeAndDo(a)
+		client.setScope(a)
 		w.WriteMsg(a)
 		return dns.RcodeSuccess, nil
 	case NoData:
-		if e.Next == nil {
-			state.SizeAndDo(a)
-			w.WriteMsg(a)
-			return dns.RcodeSuccess, nil
+		if e.Fall.Through(state.Name()) {
+			return plugin.NextOrFailure(e.Name(), e.Next, ctx, w, r)
 		}
-		return plugin.NextOrFailure(e.Name(), e.Next, ctx, w, r)
+		state.SizeAndDo(a)
+		client.setScope(a)
+		w.WriteMsg(a)
+		return dns.RcodeSuccess, nil
 	case NameError:
+		if e.Fall.Through(state.Name()) {
+			return plugin.NextOrFailure(e.Name(), e.Next, ctx, w, r)
+		}
 		a.Rcode = dns.RcodeNameError
+		state.SizeAndDo(a)
+		client.setScope(a)
+		w.WriteMsg(a)
+		return dns.RcodeNameError, nil
 	case ServerFailure:
+		if chase.err != nil {
+			a.Answer, a.Ns, a.Extra = nil, nil, nil
+			a.Rcode = dns.RcodeServerFailure
+			addExtendedError(state, a, dns.ExtendedErrorCodeOther, chase.err.Error())
+			state.SizeAndDo(a)
+			client.setScope(a)
+			w.WriteMsg(a)
+			// The response has been written, so the server must not write another.
+			return dns.RcodeSuccess, nil
+		}
 		return dns.RcodeServerFailure, nil
 	}
 	// Unknown result...
@@ -305,44 +562,78 @@ func (e ENS) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (in
 
 }
 
-func (e ENS) obtainARRSet(name string, domain string) ([]byte, error) {
+// writeBlocked writes the response to a request for a blocked name, returning
+// the rcode for the plugin to return.
+func (e ENS) writeBlocked(ctx context.Context, state request.Request, w dns.ResponseWriter, a *dns.Msg, client *client, entry *queryLogEntry, reason string) int {
+	log.Infof("blocked request for %s: %s", state.Name(), reason)
+	recordPath(ctx, state.QType(), "blocked")
+	blockedCount.Inc()
+	// The name's own TTL text record is not consulted for a blocked name.
+	rcode := e.Policy.respond(state, a, e.configuredTTL(state.QType()))
+	client.setScope(a)
+	entry.finish("Blocked")
+	w.WriteMsg(a)
+	return rcode
+}
+
+// addExtendedError adds an extended DNS error to a response, if the request
+// used EDNS.  It is called before state.SizeAndDo(), which sizes the OPT
+// record it adds from that of the request.
+func addExtendedError(state request.Request, m *dns.Msg, code uint16, text string) {
+	if state.Req.IsEdns0() == nil {
+		return
+	}
+	opt := m.IsEdns0()
+	if opt == nil {
+		m.SetEdns0(dns.MinMsgSize, false)
+		opt = m.IsEdns0()
+	}
+	opt.Option = append(opt.Option, &dns.EDNS0_EDE{InfoCode: code, ExtraText: text})
+}
+
+func (e ENS) obtainARRSet(ctx context.Context, name string, domain string) ([]byte, error) {
 	ethDomain := strings.TrimSuffix(domain, ".")
-	resolver, err := e.getDNSResolver(ethDomain)
+	resolver, err := e.getDNSResolver(ctx, ethDomain)
 	if err != nil {
 		return []byte{}, nil
 	}
 
-	return resolver.Record(name, dns.TypeA)
+	return dnsRecord(ctx, resolver, ethDomain, name, dns.TypeA)
 }
 
-func (e ENS) obtainAAAARRSet(name string, domain string) ([]byte, error) {
+func (e ENS) obtainAAAARRSet(ctx context.Context, name string, domain string) ([]byte, error) {
 	ethDomain := strings.TrimSuffix(domain, ".")
-	resolver, err := e.getDNSResolver(ethDomain)
+	resolver, err := e.getDNSResolver(ctx, ethDomain)
 	if err != nil {
 		return []byte{}, nil
 	}
 
-	return resolver.Record(name, dns.TypeAAAA)
+	return dnsRecord(ctx, resolver, ethDomain, name, dns.TypeAAAA)
 }
 
-func (e ENS) obtainContentHash(name string, domain string) ([]byte, error) {
+func (e ENS) obtainContentHash(ctx context.Context, name string, domain string) ([]byte, error) {
 	ethDomain := strings.TrimSuffix(domain, ".")
-	resolver, err := e.getResolver(ethDomain)
+	info, err := e.resolverInfo(ctx, ethDomain)
 	if err != nil {
 		return []byte{}, nil
 	}
+	queryLogEntryFrom(ctx).addResolver(info.address.Hex())
 
-	return resolver.Contenthash()
+	if info.capabilities.supports(capContenthash) {
+		return contenthash(ctx, info.resolver, ethDomain)
+	}
+	// Fall back to the records that predate contenthash.
+	return e.legacyContenthash(ctx, info, ethDomain)
 }
 
-func (e ENS) obtainTXTRRSet(name string, domain string) ([]byte, error) {
+func (e ENS) obtainTXTRRSet(ctx context.Context, name string, domain string) ([]byte, error) {
 	ethDomain := strings.TrimSuffix(domain, ".")
-	resolver, err := e.getDNSResolver(ethDomain)
+	resolver, err := e.getDNSResolver(ctx, ethDomain)
 	if err != nil {
 		return []byte{}, nil
 	}
 
-	return resolver.Record(name, dns.TypeTXT)
+	return dnsRecord(ctx, resolver, ethDomain, name, dns.TypeTXT)
 }
 
 // Name implements the Handler interface.
@@ -354,76 +645,3 @@ func (e ENS) Name() string { return "ens" }
 func isRealOnChainDomain(name string, domain string) bool {
 	return name == domain
 }
-
-var resolverCache *lru.Cache
-var dnsResolverCache *lru.Cache
-
-func init() {
-	resolverCache, _ = lru.New(16)
-	dnsResolverCache, _ = lru.New(16)
-}
-
-func (e *ENS) getDNSResolver(domain string) (*ens.DNSResolver, error) {
-	if !dnsResolverCache.Contains(domain) {
-		resolver, err := ens.NewDNSResolver(e.Client, domain)
-		if err == nil {
-			dnsResolverCache.Add(domain, resolver)
-		} else {
-			if err.Error() == "no contract code at given address" ||
-				strings.HasSuffix(err.Error(), " is not a DNS resolver contract") {
-				dnsResolverCache.Add(domain, nil)
-			}
-		}
-	}
-	resolver, _ := dnsResolverCache.Get(domain)
-	if resolver == nil {
-		return nil, errors.New("no resolver")
-	}
-	return resolver.(*ens.DNSResolver), nil
-}
-
-func (e *ENS) newDNSResolver(domain string) (*ens.DNSResolver, error) {
-	// Obtain the resolver address for this domain
-	resolver, err := e.Registry.ResolverAddress(domain)
-	if err != nil {
-		return nil, err
-	}
-	return ens.NewDNSResolverAt(e.Client, domain, resolver)
-}
-
-func (e *ENS) getResolver(domain string) (*ens.Resolver, error) {
-	if !resolverCache.Contains(domain) {
-		resolver, err := e.newResolver(domain)
-		if err == nil {
-			resolverCache.Add(domain, resolver)
-		} else {
-			if err.Error() == "no contract code at given address" ||
-				strings.HasSuffix(err.Error(), " is not a resolver contract") {
-				resolverCache.Add(domain, nil)
-			}
-		}
-	}
-	resolver, _ := resolverCache.Get(domain)
-	if resolver == nil {
-		return nil, errors.New("no resolver")
-	}
-	return resolver.(*ens.Resolver), nil
-}
-
-func (e *ENS) newResolver(domain string) (*ens.Resolver, error) {
-	// Obtain the resolver address for this domain
-	resolver, err := e.Registry.ResolverAddress(domain)
-	if err != nil {
-		return nil, err
-	}
-	return ens.NewResolverAt(e.Client, domain, resolver)
-}
-
-// Ready returns true if we're ready to serve DNS records i.e. our chain is synced
-func (e ENS) Ready() bool {
-	progress, err := e.Client.SyncProgress(context.Background())
-	if err != nil {
-		return false
-	}
-	return progress == nil
-}
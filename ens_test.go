@@ -0,0 +1,188 @@
+package ens
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/miekg/dns"
+	ens "github.com/wealdtech/go-ens/v3"
+)
+
+func TestServeDNSOutOfZone(t *testing.T) {
+	e := ENS{
+		Next:           test.NextHandler(dns.RcodeRefused, nil),
+		Zones:          []string{"eth.link."},
+		SuffixMappings: []suffixMapping{{dns: "eth.link.", ens: "eth."}},
+	}
+
+	r := new(dns.Msg)
+	r.SetQuestion("example.org.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	rcode, err := e.ServeDNS(context.Background(), rec, r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rcode != dns.RcodeRefused {
+		t.Fatalf("Request not passed to next plugin; rcode %d", rcode)
+	}
+}
+
+func TestInZones(t *testing.T) {
+	e := ENS{
+		Zones:          []string{"eth.link.", "wealdtech.eth."},
+		SuffixMappings: []suffixMapping{{dns: "eth.link.", ens: "eth."}, {dns: "gno.link.", ens: "gno."}},
+	}
+
+	tests := []struct {
+		name   string
+		result bool
+	}{
+		{"wealdtech.eth.", true},
+		{"www.wealdtech.eth.", true},
+		{"other.eth.", true},
+		{"eth.", true},
+		{"wealdtech.gno.", false},
+		{"wealdtech.xyz.", false},
+	}
+
+	for _, tt := range tests {
+		if result := e.inZones(tt.name); result != tt.result {
+			t.Errorf("Failure: %v => %v (expected %v)", tt.name, result, tt.result)
+		}
+	}
+}
+
+func TestServeDNSTimeout(t *testing.T) {
+	backend := &instrumentedBackend{ContractBackend: &blockingBackend{}}
+	registry, err := ens.NewRegistry(backend)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	e := ENS{
+		Next:          test.NextHandler(dns.RcodeRefused, nil),
+		Backend:       backend,
+		Registry:      registry,
+		Zones:         []string{"eth."},
+		LookupTimeout: 20 * time.Millisecond,
+	}
+
+	r := new(dns.Msg)
+	r.SetQuestion("wealdtech.eth.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	rcode, err := e.ServeDNS(context.Background(), rec, r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rcode != dns.RcodeServerFailure {
+		t.Fatalf("Unexpected rcode %d", rcode)
+	}
+}
+
+func TestServeDNSRPCTimeout(t *testing.T) {
+	// A single call times out well within the lookup timeout.
+	backend := &instrumentedBackend{ContractBackend: &blockingBackend{}, timeout: 10 * time.Millisecond}
+	registry, err := ens.NewRegistry(backend)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	e := ENS{
+		Next:          test.NextHandler(dns.RcodeRefused, nil),
+		Backend:       backend,
+		Registry:      registry,
+		Zones:         []string{"eth."},
+		LookupTimeout: 5 * time.Second,
+	}
+
+	r := new(dns.Msg)
+	r.SetQuestion("rpctimeout.eth.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	rcode, err := e.ServeDNS(context.Background(), rec, r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rcode != dns.RcodeServerFailure {
+		t.Fatalf("Unexpected rcode %d", rcode)
+	}
+}
+
+func TestServeDNSChaseFailure(t *testing.T) {
+	// The name is a CNAME for itself.
+	cname, err := dns.NewRR("chaseloop.eth. 300 IN CNAME chaseloop.eth.")
+	if err != nil {
+		t.Fatalf("Failed to create record: %v", err)
+	}
+	data := make([]byte, 512)
+	n, err := dns.PackRR(cname, data, 0, nil, false)
+	if err != nil {
+		t.Fatalf("Failed to pack record: %v", err)
+	}
+	backend := &methodBackend{results: map[string][]byte{
+		"owner(bytes32)":                    abiWords(common.HexToAddress("0x388Ea662EF2c223eC0B047D41Bf3c0f362142ad5")),
+		"resolver(bytes32)":                 abiWords(common.HexToAddress("0x7B1aB5D4E0D8A2E9e8F3D6F2fB6c1E8A1E2c3D4F")),
+		"supportsInterface(bytes4)":         abiWords(1),
+		"contenthash(bytes32)":              abiBytes(nil),
+		"dnsRecord(bytes32,bytes32,uint16)": abiBytes(data[:n]),
+		"hasDNSRecords(bytes32,bytes32)":    abiWords(1),
+	}}
+	registry, err := ens.NewRegistry(backend)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	e := ENS{
+		Next:          test.NextHandler(dns.RcodeRefused, nil),
+		Backend:       backend,
+		Registry:      registry,
+		Zones:         []string{"eth."},
+		LookupTimeout: 5 * time.Second,
+	}
+
+	r := new(dns.Msg)
+	r.SetQuestion("chaseloop.eth.", dns.TypeA)
+	r.SetEdns0(4096, false)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	rcode, err := e.ServeDNS(context.Background(), rec, r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The response is written by the plugin, so the server must not write another.
+	if rcode != dns.RcodeSuccess {
+		t.Fatalf("Unexpected rcode %d", rcode)
+	}
+	if rec.Msg == nil || rec.Msg.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("Unexpected response %v", rec.Msg)
+	}
+	opt := rec.Msg.IsEdns0()
+	if opt == nil || opt.UDPSize() != 4096 || len(opt.Option) != 1 {
+		t.Fatalf("Unexpected OPT record %v", opt)
+	}
+	if ede, ok := opt.Option[0].(*dns.EDNS0_EDE); !ok || ede.InfoCode != dns.ExtendedErrorCodeOther || ede.ExtraText != "loop detected at chaseloop.eth." {
+		t.Fatalf("Unexpected option %v", opt.Option[0])
+	}
+}
+
+func TestAddExtendedError(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("wealdtech.eth.", dns.TypeA)
+	state := request.Request{W: &test.ResponseWriter{}, Req: r}
+	m := new(dns.Msg)
+	addExtendedError(state, m, dns.ExtendedErrorCodeOther, "loop")
+	if m.IsEdns0() != nil {
+		t.Fatalf("OPT record added to response")
+	}
+
+	r.SetEdns0(4096, false)
+	addExtendedError(state, m, dns.ExtendedErrorCodeOther, "loop")
+	state.SizeAndDo(m)
+	opt := m.IsEdns0()
+	if opt == nil || opt.UDPSize() != 4096 || len(opt.Option) != 1 {
+		t.Fatalf("Unexpected OPT record %v", opt)
+	}
+	if ede, ok := opt.Option[0].(*dns.EDNS0_EDE); !ok || ede.InfoCode != dns.ExtendedErrorCodeOther || ede.ExtraText != "loop" {
+		t.Fatalf("Unexpected option %v", opt.Option[0])
+	}
+}
@@ -0,0 +1,196 @@
+package ens
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	lru "github.com/hashicorp/golang-lru"
+	ens "github.com/wealdtech/go-ens/v3"
+	"github.com/wealdtech/go-ens/v3/contracts/baseregistrar"
+)
+
+// defaultNameWrapper is the address of the ENS NameWrapper on mainnet.
+var defaultNameWrapper = common.HexToAddress("0xD4416b13d2b3a9aBae7AcD5D6C2BbDBE25686401")
+
+// expiryCacheTTL is the time for which the expiry of a name is cached.
+const expiryCacheTTL = time.Minute
+
+// nameWrapperABI is the part of the NameWrapper ABI used to obtain the
+// state of wrapped names.
+const nameWrapperABI = `[{"inputs":[{"internalType":"uint256","name":"id","type":"uint256"}],"name":"getData","outputs":[{"internalType":"address","name":"owner","type":"address"},{"internalType":"uint32","name":"fuses","type":"uint32"},{"internalType":"uint64","name":"expiry","type":"uint64"}],"stateMutability":"view","type":"function"}]`
+
+var parsedNameWrapperABI abi.ABI
+
+func init() {
+	var err error
+	parsedNameWrapperABI, err = abi.JSON(strings.NewReader(nameWrapperABI))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// expiryChecker checks if names have expired, either because their .eth
+// registration has expired or because they are wrapped and the NameWrapper
+// no longer recognises their owner.  Names continue to be served for the
+// grace period after they expire.
+type expiryChecker struct {
+	grace       time.Duration
+	nameWrapper common.Address
+
+	mu        sync.Mutex
+	registrar *baseregistrar.ContractCaller
+	// expiries are the cached expiries of .eth names.
+	expiries *lru.Cache
+	// wrapped are the cached owners and expiries of wrapped names.
+	wrapped *lru.Cache
+}
+
+// expiryCacheEntry is a cached expiry of a .eth name.
+type expiryCacheEntry struct {
+	expiry  time.Time
+	fetched time.Time
+}
+
+// wrappedCacheEntry is the cached owner and expiry of a wrapped name.
+type wrappedCacheEntry struct {
+	owner   common.Address
+	expiry  time.Time
+	fetched time.Time
+}
+
+func newExpiryChecker(grace time.Duration, nameWrapper common.Address) *expiryChecker {
+	expiries, _ := lru.New(1024)
+	wrapped, _ := lru.New(1024)
+	return &expiryChecker{
+		grace:       grace,
+		nameWrapper: nameWrapper,
+		expiries:    expiries,
+		wrapped:     wrapped,
+	}
+}
+
+// expired returns true if a domain with the given registry owner has expired.
+// A wrapped name has expired if the NameWrapper reports no owner for it,
+// which it does once the name's expiry has passed if its parent has given up
+// control of it (the PARENT_CANNOT_CONTROL fuse); names still controlled by
+// their parent do not expire.  Failures to obtain expiry information are
+// treated as the domain not having expired.
+func (e ENS) expired(ctx context.Context, domain string, owner common.Address) bool {
+	if e.Expiry == nil {
+		return false
+	}
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	now := time.Now()
+
+	if owner == e.Expiry.nameWrapper {
+		wrappedOwner, expiry, err := e.Expiry.wrappedData(ctx, e.Backend, domain)
+		if err != nil {
+			log.Debugf("failed to obtain wrapped data for %s: %v", domain, err)
+		} else if wrappedOwner == ens.UnknownAddress && now.After(expiry.Add(e.Expiry.grace)) {
+			log.Debugf("wrapped name %s expired at %v", domain, expiry)
+			return true
+		}
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) >= 2 && labels[len(labels)-1] == "eth" {
+		name := strings.Join(labels[len(labels)-2:], ".")
+		expiry, err := e.Expiry.registrationExpiry(ctx, e, name)
+		if err != nil {
+			log.Debugf("failed to obtain expiry for %s: %v", name, err)
+		} else if !expiry.IsZero() && now.After(expiry.Add(e.Expiry.grace)) {
+			log.Debugf("registration of %s expired at %v", name, expiry)
+			return true
+		}
+	}
+
+	return false
+}
+
+// registrationExpiry obtains the expiry of a .eth second-level domain from the
+// registrar.  A zero time is returned for names that are not registered.
+func (x *expiryChecker) registrationExpiry(ctx context.Context, e ENS, name string) (time.Time, error) {
+	if cached, exists := x.expiries.Get(name); exists {
+		entry := cached.(*expiryCacheEntry)
+		if time.Since(entry.fetched) < expiryCacheTTL {
+			return entry.expiry, nil
+		}
+	}
+
+	registrar, err := x.registrarContract(ctx, e)
+	if err != nil {
+		return time.Time{}, err
+	}
+	labelHash, err := ens.LabelHash(strings.TrimSuffix(name, ".eth"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	res, err := registrar.NameExpires(callOpts(ctx), new(big.Int).SetBytes(labelHash[:]))
+	if err != nil {
+		return time.Time{}, err
+	}
+	var expiry time.Time
+	if res.Sign() > 0 {
+		expiry = time.Unix(res.Int64(), 0)
+	}
+	x.expiries.Add(name, &expiryCacheEntry{expiry: expiry, fetched: time.Now()})
+	return expiry, nil
+}
+
+// registrarContract obtains the .eth registrar, which is the owner of eth in
+// the registry.
+func (x *expiryChecker) registrarContract(ctx context.Context, e ENS) (*baseregistrar.ContractCaller, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.registrar != nil {
+		return x.registrar, nil
+	}
+	address, err := e.owner(ctx, "eth")
+	if err != nil {
+		return nil, err
+	}
+	if address == ens.UnknownAddress {
+		return nil, errors.New("no registrar for eth")
+	}
+	registrar, err := baseregistrar.NewContractCaller(address, e.Backend)
+	if err != nil {
+		return nil, err
+	}
+	x.registrar = registrar
+	return registrar, nil
+}
+
+// wrappedData obtains the owner and expiry of a wrapped domain from the
+// NameWrapper.  The NameWrapper clears the fuses of names that have expired,
+// so the PARENT_CANNOT_CONTROL fuse cannot be read for them; instead it
+// reports no owner for expired names that had the fuse set, which is what
+// the owner returned here reflects.
+func (x *expiryChecker) wrappedData(ctx context.Context, backend bind.ContractBackend, domain string) (common.Address, time.Time, error) {
+	if cached, exists := x.wrapped.Get(domain); exists {
+		entry := cached.(*wrappedCacheEntry)
+		if time.Since(entry.fetched) < expiryCacheTTL {
+			return entry.owner, entry.expiry, nil
+		}
+	}
+
+	nameHash, err := ens.NameHash(domain)
+	if err != nil {
+		return ens.UnknownAddress, time.Time{}, err
+	}
+	contract := bind.NewBoundContract(x.nameWrapper, parsedNameWrapperABI, backend, nil, nil)
+	var out []interface{}
+	if err := contract.Call(callOpts(ctx), &out, "getData", new(big.Int).SetBytes(nameHash[:])); err != nil {
+		return ens.UnknownAddress, time.Time{}, err
+	}
+	owner := *abi.ConvertType(out[0], new(common.Address)).(*common.Address)
+	expiry := time.Unix(int64(*abi.ConvertType(out[2], new(uint64)).(*uint64)), 0)
+	x.wrapped.Add(domain, &wrappedCacheEntry{owner: owner, expiry: expiry, fetched: time.Now()})
+	return owner, expiry, nil
+}
@@ -0,0 +1,129 @@
+package ens
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	ens "github.com/wealdtech/go-ens/v3"
+)
+
+// methodBackend is a contract backend that returns canned results for calls
+// by method.
+type methodBackend struct {
+	bind.ContractBackend
+	results map[string][]byte
+	// calls, if set, counts the calls made to each method.
+	calls map[string]int
+}
+
+func (m *methodBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if len(call.Data) >= 8 && string(call.Data[:4]) == string(erc165InterfaceID[:]) && string(call.Data[4:8]) == string(invalidInterfaceID[:]) {
+		// ERC-165 requires the invalid interface to be unsupported.
+		return abiWords(0), nil
+	}
+	for method, result := range m.results {
+		if string(crypto.Keccak256([]byte(method))[:4]) == string(call.Data[:4]) {
+			if m.calls != nil {
+				m.calls[method]++
+			}
+			return result, nil
+		}
+	}
+	return nil, nil
+}
+
+// abiWords encodes values as consecutive ABI words.
+func abiWords(values ...interface{}) []byte {
+	res := make([]byte, 0)
+	for _, value := range values {
+		switch v := value.(type) {
+		case common.Address:
+			res = append(res, common.LeftPadBytes(v.Bytes(), 32)...)
+		case time.Time:
+			res = append(res, common.LeftPadBytes(big.NewInt(v.Unix()).Bytes(), 32)...)
+		case int:
+			res = append(res, common.LeftPadBytes(big.NewInt(int64(v)).Bytes(), 32)...)
+		}
+	}
+	return res
+}
+
+func TestExpired(t *testing.T) {
+	registrar := common.HexToAddress("0x57f1887a8BF19b14fC0dF6Fd9B2acc9Af147eA85")
+	owner := common.HexToAddress("0x388Ea662EF2c223eC0B047D41Bf3c0f362142ad5")
+	future := time.Now().Add(24 * time.Hour)
+	past := time.Now().Add(-24 * time.Hour)
+
+	tests := []struct {
+		name       string
+		domain     string
+		owner      common.Address
+		grace      time.Duration
+		expires    time.Time
+		wrappedOwn common.Address
+		wrappedExp time.Time
+		expired    bool
+	}{
+		{name: "Registered", domain: "wealdtech.eth.", owner: owner, expires: future},
+		{name: "Expired", domain: "wealdtech.eth.", owner: owner, expires: past, expired: true},
+		{name: "ExpiredSubdomain", domain: "www.wealdtech.eth.", owner: owner, expires: past, expired: true},
+		{name: "InGracePeriod", domain: "wealdtech.eth.", owner: owner, grace: 48 * time.Hour, expires: past},
+		{name: "NotRegistered", domain: "wealdtech.eth.", owner: owner},
+		{name: "NotEth", domain: "wealdtech.xyz.", owner: owner, expires: past},
+		{name: "Wrapped", domain: "www.wealdtech.eth.", owner: defaultNameWrapper, expires: future, wrappedOwn: owner, wrappedExp: future},
+		{name: "WrappedExpired", domain: "www.wealdtech.eth.", owner: defaultNameWrapper, expires: future, wrappedExp: past, expired: true},
+		{name: "WrappedParentControlled", domain: "www.wealdtech.eth.", owner: defaultNameWrapper, expires: future, wrappedOwn: owner, wrappedExp: past},
+		{name: "WrappedInGracePeriod", domain: "www.wealdtech.eth.", owner: defaultNameWrapper, grace: 48 * time.Hour, expires: future, wrappedExp: past},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var expires interface{} = 0
+			if !test.expires.IsZero() {
+				expires = test.expires
+			}
+			backend := &methodBackend{results: map[string][]byte{
+				"owner(bytes32)":       abiWords(registrar),
+				"nameExpires(uint256)": abiWords(expires),
+				"getData(uint256)":     abiWords(test.wrappedOwn, 0, test.wrappedExp),
+			}}
+			registry, err := ens.NewRegistry(backend)
+			if err != nil {
+				t.Fatalf("Failed to create registry: %v", err)
+			}
+			e := ENS{Backend: backend, Registry: registry, Expiry: newExpiryChecker(test.grace, defaultNameWrapper)}
+			if expired := e.expired(context.Background(), test.domain, test.owner); expired != test.expired {
+				t.Fatalf("Expired %t (expected %t)", expired, test.expired)
+			}
+		})
+	}
+}
+
+func TestExpiredWrappedCache(t *testing.T) {
+	owner := common.HexToAddress("0x388Ea662EF2c223eC0B047D41Bf3c0f362142ad5")
+	backend := &methodBackend{results: map[string][]byte{
+		"owner(bytes32)":       abiWords(common.HexToAddress("0x57f1887a8BF19b14fC0dF6Fd9B2acc9Af147eA85")),
+		"nameExpires(uint256)": abiWords(time.Now().Add(24 * time.Hour)),
+		"getData(uint256)":     abiWords(owner, 0, time.Now().Add(24*time.Hour)),
+	}}
+	registry, err := ens.NewRegistry(backend)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	e := ENS{Backend: backend, Registry: registry, Expiry: newExpiryChecker(0, defaultNameWrapper)}
+	if e.expired(context.Background(), "cached.wealdtech.eth.", defaultNameWrapper) {
+		t.Fatalf("Name expired")
+	}
+
+	// The wrapped data is cached, so a change is not seen immediately.
+	backend.results["getData(uint256)"] = abiWords(0, 0, time.Now().Add(-24*time.Hour))
+	if e.expired(context.Background(), "cached.wealdtech.eth.", defaultNameWrapper) {
+		t.Fatalf("Wrapped data not cached")
+	}
+}
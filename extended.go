@@ -0,0 +1,98 @@
+package ens
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/crypto"
+	lru "github.com/hashicorp/golang-lru"
+	ens "github.com/wealdtech/go-ens/v3"
+	dnsresolvercontract "github.com/wealdtech/go-ens/v3/contracts/dnsresolver"
+	resolvercontract "github.com/wealdtech/go-ens/v3/contracts/resolver"
+)
+
+// extendedCapabilities are the capabilities of a domain resolved by an
+// extended resolver.  The resolver is asked for each record with resolve(),
+// which reverts for records it does not hold.
+const extendedCapabilities = capAddr | capMulticoin | capContenthash | capText | capDNS
+
+// resolveSelector is the selector of resolve(bytes,bytes) (ENSIP-10).
+var resolveSelector = crypto.Keccak256([]byte("resolve(bytes,bytes)"))[:4]
+
+// resolveArgs are the arguments of resolve(): the DNS wire format name and
+// the call to resolve it with.  The result is the result of the call.
+var resolveArgs abi.Arguments
+
+// extendedCache holds the resolver information for each domain resolved by
+// an extended resolver.
+var extendedCache *lru.Cache
+
+func init() {
+	bytesType, _ := abi.NewType("bytes", "", nil)
+	resolveArgs = abi.Arguments{{Type: bytesType}, {Type: bytesType}}
+	extendedCache, _ = lru.NewWithEvict(256, func(key interface{}, value interface{}) {
+		cacheEvictions.WithLabelValues("extended").Inc()
+	})
+}
+
+// extendedResolverInfo returns the resolver information for a domain that is
+// resolved by the extended resolver of an ancestor.
+func (e ENS) extendedResolverInfo(domain string, parent string, parentInfo *resolverInfo) (*resolverInfo, error) {
+	if cached, exists := extendedCache.Get(domain); exists {
+		if entry := cached.(*capabilityCacheEntry); time.Since(entry.fetched) < capabilityCacheTTL && entry.info.address == parentInfo.address {
+			cacheHits.WithLabelValues("extended").Inc()
+			return entry.info, nil
+		}
+	}
+	cacheMisses.WithLabelValues("extended").Inc()
+
+	backend := &extendedResolverBackend{ContractBackend: e.Backend, name: ens.DNSWireFormat(domain)}
+	info := &resolverInfo{
+		address:      parentInfo.address,
+		capabilities: extendedCapabilities,
+		parent:       parent,
+	}
+	contract, err := resolvercontract.NewContract(parentInfo.address, backend)
+	if err != nil {
+		return nil, err
+	}
+	info.resolver = &ens.Resolver{Contract: contract, ContractAddr: parentInfo.address}
+	dnsContract, err := dnsresolvercontract.NewContract(parentInfo.address, backend)
+	if err != nil {
+		return nil, err
+	}
+	info.dnsResolver = &ens.DNSResolver{Contract: dnsContract, ContractAddr: parentInfo.address}
+
+	extendedCache.Add(domain, &capabilityCacheEntry{info: info, fetched: time.Now()})
+	return info, nil
+}
+
+// extendedResolverBackend is a contract backend that makes calls to a resolver
+// through its resolve() function on behalf of a name.
+type extendedResolverBackend struct {
+	bind.ContractBackend
+	name []byte
+}
+
+// CallContract executes a contract call through resolve().
+func (b *extendedResolverBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	args, err := resolveArgs.Pack(b.name, call.Data)
+	if err != nil {
+		return nil, err
+	}
+	call.Data = append(append([]byte{}, resolveSelector...), args...)
+	res, err := b.ContractBackend.CallContract(ctx, call, blockNumber)
+	if err != nil || len(res) == 0 {
+		return res, err
+	}
+	values, err := resolveArgs[:1].Unpack(res)
+	if err != nil {
+		return nil, fmt.Errorf("invalid result from resolve(): %v", err)
+	}
+	return values[0].([]byte), nil
+}
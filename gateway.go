@@ -0,0 +1,44 @@
+package ens
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// contenthashCodecs are the contenthash codecs for which gateways can be
+// configured, keyed by multicodec ID.
+var contenthashCodecs = map[uint64]string{
+	0xe3:     "ipfs-ns",
+	0xe4:     "swarm-ns",
+	0xe5:     "ipns-ns",
+	0x01bc:   "onion",
+	0x01bd:   "onion3",
+	0xb19910: "skynet-ns",
+	0xb29910: "arweave-ns",
+}
+
+// contenthashCodec returns the name of the codec of a contenthash.
+func contenthashCodec(contentHash []byte) (string, error) {
+	id, size := binary.Uvarint(contentHash)
+	if size <= 0 {
+		return "", errors.New("invalid contenthash codec")
+	}
+	codec, exists := contenthashCodecs[id]
+	if !exists {
+		return "", errors.New("unsupported contenthash codec")
+	}
+	return codec, nil
+}
+
+// contenthashCodecName returns the canonical name of a contenthash codec
+// supplied in configuration.  The "-ns" suffix is optional.
+func contenthashCodecName(input string) (string, bool) {
+	input = strings.ToLower(input)
+	for _, codec := range contenthashCodecs {
+		if codec == input || codec == input+"-ns" {
+			return codec, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,45 @@
+package ens
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func TestContenthashCodec(t *testing.T) {
+	arweave := make([]byte, binary.MaxVarintLen64)
+	arweave = append(arweave[:binary.PutUvarint(arweave, 0xb29910)], 0x01, 0x02)
+
+	tests := []struct {
+		contenthash string
+		codec       string
+		err         bool
+	}{
+		{"e3010170122029f2d17be6139079dc48696d1f582a8530eb9805b561eda517e22a892c7e3f1f", "ipfs-ns", false},
+		{"e50101720024080112205cbd1cc86ac20d6640795809c2a185bb2504538a2de8076da5a6971b8acb4715", "ipns-ns", false},
+		{"e40101fa011b20d1de9994b4d039f6548d191eb26786769f580809256b4685ef316805265ea162", "swarm-ns", false},
+		{"bd037a71617472736b6b6b657a6432676b68693364647133736e6f6e7472376b636c6c6d337633356b6c61726561716c77356e746a667a64697966", "onion3", false},
+		{hex.EncodeToString(arweave), "arweave-ns", false},
+		{"70", "", true},
+		{"", "", true},
+	}
+
+	for i, tt := range tests {
+		contenthash, err := hex.DecodeString(tt.contenthash)
+		if err != nil {
+			t.Fatalf("Test %d bad contenthash: %v", i, err)
+		}
+		codec, err := contenthashCodec(contenthash)
+		if tt.err {
+			if err == nil {
+				t.Errorf("Test %d expected error, got %s", i, codec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d unexpected error: %v", i, err)
+		} else if codec != tt.codec {
+			t.Errorf("Test %d: %s (expected %s)", i, codec, tt.codec)
+		}
+	}
+}
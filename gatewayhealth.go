@@ -0,0 +1,187 @@
+package ens
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultGatewayCheckInterval is the default interval between probes of
+	// the gateway addresses.
+	defaultGatewayCheckInterval = 10 * time.Second
+	// defaultGatewayUnhealthyThreshold is the default number of consecutive
+	// failed probes after which a gateway address is unhealthy.
+	defaultGatewayUnhealthyThreshold = 3
+	// defaultGatewayHealthyThreshold is the default number of consecutive
+	// successful probes after which an unhealthy gateway address is healthy.
+	defaultGatewayHealthyThreshold = 2
+	// gatewayCheckTimeout is the maximum time allowed for a single probe.
+	gatewayCheckTimeout = 5 * time.Second
+)
+
+// gatewayState is the health of a gateway address.
+type gatewayState struct {
+	healthy   bool
+	failures  int
+	successes int
+}
+
+// gatewayChecker probes the gateway addresses and tracks which are healthy.
+// Each address is probed with a TCP connection and, if a CID is configured,
+// an HTTP request for that CID.
+type gatewayChecker struct {
+	addresses          []string
+	port               int
+	cid                string
+	interval           time.Duration
+	unhealthyThreshold int
+	healthyThreshold   int
+	client             *http.Client
+	// probe checks a single address; it is replaceable for testing.
+	probe func(ctx context.Context, address string) error
+
+	mu     sync.RWMutex
+	states map[string]*gatewayState
+}
+
+// newGatewayChecker creates a checker for all of the configured gateway
+// addresses.  All addresses start healthy.
+func newGatewayChecker(cfg *config) *gatewayChecker {
+	g := &gatewayChecker{
+		port:               cfg.gatewayCheckPort,
+		cid:                cfg.gatewayCheckCID,
+		interval:           cfg.gatewayCheckInterval,
+		unhealthyThreshold: cfg.gatewayUnhealthyThreshold,
+		healthyThreshold:   cfg.gatewayHealthyThreshold,
+		client: &http.Client{
+			// Gateways redirect to subdomains, which are not being checked.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		states: make(map[string]*gatewayState),
+	}
+	g.probe = g.probeAddress
+	for _, gateways := range []map[string][]string{cfg.gatewayAs, cfg.gatewayAAAAs, cfg.gatewayPools} {
+		for _, addresses := range gateways {
+			for _, address := range addresses {
+				if _, exists := g.states[address]; !exists {
+					g.states[address] = &gatewayState{healthy: true}
+					g.addresses = append(g.addresses, address)
+					gatewayHealthy.WithLabelValues(address).Set(1)
+				}
+			}
+		}
+	}
+	return g
+}
+
+// run probes the gateway addresses periodically until stopped.
+func (g *gatewayChecker) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	for {
+		g.check()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check probes all of the gateway addresses.
+func (g *gatewayChecker) check() {
+	timeout := gatewayCheckTimeout
+	if g.interval < timeout {
+		timeout = g.interval
+	}
+	var wg sync.WaitGroup
+	for _, address := range g.addresses {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			g.record(address, g.probe(ctx, address))
+		}(address)
+	}
+	wg.Wait()
+}
+
+// probeAddress checks that a gateway address accepts connections and, if a
+// CID is configured, that it serves the CID.
+func (g *gatewayChecker) probeAddress(ctx context.Context, address string) error {
+	hostPort := net.JoinHostPort(address, strconv.Itoa(g.port))
+	if g.cid == "" {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", hostPort)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/ipfs/%s", hostPort, g.cid), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := g.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// record updates the state of a gateway address with the result of a probe.
+func (g *gatewayChecker) record(address string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	state := g.states[address]
+	if err != nil {
+		gatewayProbeFailures.WithLabelValues(address).Inc()
+		state.failures++
+		state.successes = 0
+		if state.healthy && state.failures >= g.unhealthyThreshold {
+			log.Warningf("gateway %s is unhealthy: %v", address, err)
+			state.healthy = false
+			gatewayHealthy.WithLabelValues(address).Set(0)
+		}
+		return
+	}
+	state.successes++
+	state.failures = 0
+	if !state.healthy && state.successes >= g.healthyThreshold {
+		log.Infof("gateway %s is healthy", address)
+		state.healthy = true
+		gatewayHealthy.WithLabelValues(address).Set(1)
+	}
+}
+
+// filter returns the healthy addresses of those supplied.  If none of them
+// are healthy all of them are returned, as an unhealthy gateway is no worse
+// than no gateway.
+func (g *gatewayChecker) filter(addresses []string) []string {
+	if g == nil {
+		return addresses
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	healthy := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if state, exists := g.states[address]; !exists || state.healthy {
+			healthy = append(healthy, address)
+		}
+	}
+	if len(healthy) == 0 {
+		return addresses
+	}
+	return healthy
+}
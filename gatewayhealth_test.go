@@ -0,0 +1,103 @@
+package ens
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGatewayChecker(t *testing.T) {
+	cfg := &config{
+		gatewayAs:                 map[string][]string{"ipfs-ns": {"192.0.2.1", "192.0.2.2"}, "ipns-ns": {"192.0.2.1", "192.0.2.2"}},
+		gatewayAAAAs:              map[string][]string{"ipfs-ns": {"2001:db8::1"}},
+		gatewayCheckPort:          80,
+		gatewayCheckInterval:      defaultGatewayCheckInterval,
+		gatewayUnhealthyThreshold: 2,
+		gatewayHealthyThreshold:   2,
+	}
+	g := newGatewayChecker(cfg)
+	if len(g.addresses) != 3 {
+		t.Fatalf("Unexpected addresses %v", g.addresses)
+	}
+
+	down := map[string]bool{"192.0.2.2": true, "2001:db8::1": true}
+	g.probe = func(ctx context.Context, address string) error {
+		if down[address] {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	// A single failure is below the threshold.
+	g.check()
+	if res := g.filter(cfg.gatewayAs["ipfs-ns"]); len(res) != 2 {
+		t.Fatalf("Gateway unhealthy after one failure: %v", res)
+	}
+	g.check()
+	if res := g.filter(cfg.gatewayAs["ipfs-ns"]); strings.Join(res, ",") != "192.0.2.1" {
+		t.Fatalf("Unexpected healthy gateways %v", res)
+	}
+	// With no healthy gateways all are returned.
+	if res := g.filter(cfg.gatewayAAAAs["ipfs-ns"]); strings.Join(res, ",") != "2001:db8::1" {
+		t.Fatalf("Unexpected healthy gateways %v", res)
+	}
+
+	// Recovery requires the healthy threshold to be met.
+	down = map[string]bool{}
+	g.check()
+	if res := g.filter(cfg.gatewayAs["ipfs-ns"]); len(res) != 1 {
+		t.Fatalf("Gateway healthy after one success: %v", res)
+	}
+	g.check()
+	if res := g.filter(cfg.gatewayAs["ipfs-ns"]); len(res) != 2 {
+		t.Fatalf("Gateway not healthy after recovery: %v", res)
+	}
+
+	// A nil checker does not filter.
+	var none *gatewayChecker
+	if res := none.filter(cfg.gatewayAs["ipfs-ns"]); len(res) != 2 {
+		t.Fatalf("Unexpected filtering %v", res)
+	}
+}
+
+func TestGatewayProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ipfs/bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to parse server address: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	tests := []struct {
+		name string
+		cid  string
+		err  bool
+	}{
+		{name: "TCP"},
+		{name: "HTTP", cid: "bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq"},
+		{name: "HTTPMissing", cid: "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := newGatewayChecker(&config{gatewayCheckPort: port, gatewayCheckCID: test.cid})
+			err := g.probe(context.Background(), host)
+			if test.err && err == nil {
+				t.Fatalf("Expected error not returned")
+			}
+			if !test.err && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
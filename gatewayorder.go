@@ -0,0 +1,86 @@
+package ens
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// gatewayOrder is the order in which gateway addresses are returned.
+type gatewayOrder int
+
+const (
+	// gatewayOrderFixed returns addresses in the configured order.
+	gatewayOrderFixed gatewayOrder = iota
+	// gatewayOrderShuffle returns addresses in a random order.
+	gatewayOrderShuffle
+	// gatewayOrderWeighted returns addresses in a random order in which
+	// addresses with higher weights are more likely to come first.
+	gatewayOrderWeighted
+)
+
+// gatewayBalancer spreads load across gateways by ordering the addresses
+// returned in each response and limiting their number.
+type gatewayBalancer struct {
+	order gatewayOrder
+	// weights are the weights of addresses for weighted ordering; addresses
+	// without a weight have a weight of 1.
+	weights map[string]int
+	// max is the maximum number of addresses returned, or 0 for all.
+	max int
+
+	// mu guards rand, which is not safe for concurrent use.
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newGatewayBalancer(order gatewayOrder, weights map[string]int, max int) *gatewayBalancer {
+	return &gatewayBalancer{
+		order:   order,
+		weights: weights,
+		max:     max,
+		// The package-level source is not seeded, so would give the same
+		// order after every restart.
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// arrange returns the addresses in the order to be returned, limited to the
+// maximum number.  The supplied addresses are not altered.
+func (b *gatewayBalancer) arrange(addresses []string) []string {
+	if b == nil || len(addresses) == 0 {
+		return addresses
+	}
+	res := make([]string, len(addresses))
+	copy(res, addresses)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.order {
+	case gatewayOrderShuffle:
+		b.rand.Shuffle(len(res), func(i, j int) { res[i], res[j] = res[j], res[i] })
+	case gatewayOrderWeighted:
+		// Sort by a random key of u^(1/weight), which gives each address a
+		// chance of coming first in proportion to its weight.
+		keys := make(map[string]float64, len(res))
+		for _, address := range res {
+			keys[address] = math.Pow(b.rand.Float64(), 1/float64(b.weight(address)))
+		}
+		sort.SliceStable(res, func(i, j int) bool { return keys[res[i]] > keys[res[j]] })
+	}
+
+	if b.max > 0 && len(res) > b.max {
+		res = res[:b.max]
+	}
+	return res
+}
+
+// weight returns the weight of an address.
+func (b *gatewayBalancer) weight(address string) int {
+	if weight, exists := b.weights[address]; exists {
+		return weight
+	}
+	return 1
+}
@@ -0,0 +1,70 @@
+package ens
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGatewayBalancer(t *testing.T) {
+	addresses := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"}
+
+	var none *gatewayBalancer
+	if res := none.arrange(addresses); strings.Join(res, ",") != strings.Join(addresses, ",") {
+		t.Fatalf("Unexpected addresses %v", res)
+	}
+
+	fixed := newGatewayBalancer(gatewayOrderFixed, nil, 2)
+	if res := fixed.arrange(addresses); strings.Join(res, ",") != "192.0.2.1,192.0.2.2" {
+		t.Fatalf("Unexpected addresses %v", res)
+	}
+
+	shuffle := newGatewayBalancer(gatewayOrderShuffle, nil, 0)
+	res := shuffle.arrange(addresses)
+	sorted := make([]string, len(res))
+	copy(sorted, res)
+	sort.Strings(sorted)
+	if strings.Join(sorted, ",") != strings.Join(addresses, ",") {
+		t.Fatalf("Shuffled addresses %v are not a permutation", res)
+	}
+	if addresses[0] != "192.0.2.1" || addresses[3] != "192.0.2.4" {
+		t.Fatalf("Supplied addresses altered")
+	}
+}
+
+func TestGatewayBalancerWeighted(t *testing.T) {
+	addresses := []string{"192.0.2.1", "192.0.2.2"}
+	weighted := newGatewayBalancer(gatewayOrderWeighted, map[string]int{"192.0.2.2": 9}, 1)
+
+	runs := 10000
+	firsts := make(map[string]int)
+	for i := 0; i < runs; i++ {
+		res := weighted.arrange(addresses)
+		if len(res) != 1 {
+			t.Fatalf("Unexpected addresses %v", res)
+		}
+		firsts[res[0]]++
+	}
+	// The address with weight 9 should be returned 90% of the time.
+	if share := float64(firsts["192.0.2.2"]) / float64(runs); share < 0.85 || share > 0.95 {
+		t.Fatalf("Unexpected share %f for weighted address", share)
+	}
+}
+
+func TestGatewayBalancerSeeded(t *testing.T) {
+	addresses := make([]string, 20)
+	for i := range addresses {
+		addresses[i] = fmt.Sprintf("192.0.2.%d", i+1)
+	}
+
+	// Balancers created at different times use different random sources, so
+	// order addresses differently.
+	first := newGatewayBalancer(gatewayOrderShuffle, nil, 0)
+	time.Sleep(time.Millisecond)
+	second := newGatewayBalancer(gatewayOrderShuffle, nil, 0)
+	if strings.Join(first.arrange(addresses), ",") == strings.Join(second.arrange(addresses), ",") {
+		t.Fatalf("Balancers returned the same order")
+	}
+}
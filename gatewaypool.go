@@ -0,0 +1,210 @@
+package ens
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// clientKey is the context key for the client of a request.
+type clientKey struct{}
+
+// client is the network location of the client of a request, taken from the
+// EDNS Client Subnet option if present or the source address otherwise.
+type client struct {
+	ip     net.IP
+	subnet *dns.EDNS0_SUBNET
+	// located is set if an answer depends on the client's location.
+	located bool
+}
+
+// newClient obtains the client of a request.
+func newClient(state request.Request) *client {
+	if opt := state.Req.IsEdns0(); opt != nil {
+		for _, option := range opt.Option {
+			if subnet, isSubnet := option.(*dns.EDNS0_SUBNET); isSubnet && subnet.SourceNetmask > 0 {
+				return &client{ip: subnet.Address, subnet: subnet}
+			}
+		}
+	}
+	return &client{ip: net.ParseIP(state.IP())}
+}
+
+// withClient returns a context that carries the given client.
+func withClient(ctx context.Context, c *client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// clientFrom returns the client in the context, or nil if there is none.
+func clientFrom(ctx context.Context) *client {
+	c, _ := ctx.Value(clientKey{}).(*client)
+	return c
+}
+
+// setScope returns the EDNS Client Subnet option of the request in a
+// response, with its scope set to the client's subnet if the answer depends
+// on the client's location.
+func (c *client) setScope(m *dns.Msg) {
+	if c == nil || c.subnet == nil {
+		return
+	}
+	opt := m.IsEdns0()
+	if opt == nil {
+		return
+	}
+	subnet := *c.subnet
+	subnet.SourceScope = 0
+	if c.located {
+		subnet.SourceScope = subnet.SourceNetmask
+	}
+	for i, option := range opt.Option {
+		if _, isSubnet := option.(*dns.EDNS0_SUBNET); isSubnet {
+			opt.Option[i] = &subnet
+			return
+		}
+	}
+	opt.Option = append(opt.Option, &subnet)
+}
+
+// subnetPool is a subnet whose clients are served by a gateway pool.
+type subnetPool struct {
+	subnet *net.IPNet
+	pool   string
+}
+
+// geoDatabase is a database of the locations of addresses.
+type geoDatabase interface {
+	Lookup(ip net.IP, result interface{}) error
+}
+
+// geoRecord is the part of a MaxMind-format database record used to select
+// gateway pools.
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+}
+
+// gatewaySelector selects the pool of IPFS gateway addresses that serves a
+// client, by the client's subnet or by its location in a MaxMind-format
+// database.  Subnets take precedence over locations.
+type gatewaySelector struct {
+	pools    map[string][]string
+	subnets  []subnetPool
+	geo      geoDatabase
+	geoPools map[string]string
+}
+
+// newGatewaySelector creates a selector from the configuration, opening the
+// location database if there is one.
+func newGatewaySelector(cfg *config) (*gatewaySelector, error) {
+	s := &gatewaySelector{
+		pools:    cfg.gatewayPools,
+		subnets:  cfg.gatewaySubnets,
+		geoPools: cfg.gatewayGeoPools,
+	}
+	if cfg.gatewayGeoIP != "" {
+		geo, err := maxminddb.Open(cfg.gatewayGeoIP)
+		if err != nil {
+			return nil, err
+		}
+		s.geo = geo
+	}
+	return s, nil
+}
+
+// close releases the location database, if there is one.
+func (s *gatewaySelector) close() error {
+	if s == nil {
+		return nil
+	}
+	if closer, isCloser := s.geo.(io.Closer); isCloser {
+		return closer.Close()
+	}
+	return nil
+}
+
+// selectGateways returns the addresses of the given type from the pool that
+// serves the client of the request, or the fallback addresses if there is no
+// such pool or it has no addresses of the type.
+func (s *gatewaySelector) selectGateways(ctx context.Context, qtype uint16, fallback []string) []string {
+	if s == nil {
+		return fallback
+	}
+	c := clientFrom(ctx)
+	if c == nil || c.ip == nil {
+		return fallback
+	}
+	// Even the fallback depends on the client not being served by a pool.
+	c.located = true
+	pool, exists := s.pool(c.ip)
+	if !exists {
+		return fallback
+	}
+	addresses := make([]string, 0, len(s.pools[pool]))
+	for _, address := range s.pools[pool] {
+		ip := net.ParseIP(address)
+		if ip != nil && (qtype == dns.TypeA) == (ip.To4() != nil) {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		return fallback
+	}
+	return addresses
+}
+
+// pool returns the name of the pool that serves an address.
+func (s *gatewaySelector) pool(ip net.IP) (string, bool) {
+	// Use the most specific matching subnet.
+	pool := ""
+	bestSize := -1
+	for _, subnet := range s.subnets {
+		if size, _ := subnet.subnet.Mask.Size(); subnet.subnet.Contains(ip) && size > bestSize {
+			pool = subnet.pool
+			bestSize = size
+		}
+	}
+	if bestSize >= 0 {
+		return pool, true
+	}
+
+	if s.geo == nil {
+		return "", false
+	}
+	var record geoRecord
+	if err := s.geo.Lookup(ip, &record); err != nil {
+		log.Debugf("failed to look up location of %v: %v", ip, err)
+		return "", false
+	}
+	if pool, exists := s.geoPools[strings.ToUpper(record.Country.ISOCode)]; exists && record.Country.ISOCode != "" {
+		return pool, true
+	}
+	if pool, exists := s.geoPools[strings.ToUpper(record.Continent.Code)]; exists && record.Continent.Code != "" {
+		return pool, true
+	}
+	return "", false
+}
+
+// gatewayAddresses returns the addresses of the gateways of the given type
+// that serve content with a contenthash codec to the client of a request,
+// in the order in which they are to be returned.  Gateway pools apply to IPFS
+// and IPNS content.
+func (e ENS) gatewayAddresses(ctx context.Context, codec string, qtype uint16) []string {
+	addresses := e.GatewayAs[codec]
+	if qtype == dns.TypeAAAA {
+		addresses = e.GatewayAAAAs[codec]
+	}
+	if codec == "ipfs-ns" || codec == "ipns-ns" {
+		addresses = e.GatewaySelector.selectGateways(ctx, qtype, addresses)
+	}
+	return e.GatewayBalancer.arrange(e.Gateways.filter(addresses))
+}
@@ -0,0 +1,187 @@
+package ens
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/miekg/dns"
+	ens "github.com/wealdtech/go-ens/v3"
+)
+
+// fakeGeo is a location database that locates addresses by their first octet.
+type fakeGeo map[byte][2]string
+
+func (f fakeGeo) Lookup(ip net.IP, result interface{}) error {
+	location, exists := f[ip.To16()[12]]
+	if !exists {
+		return errors.New("not found")
+	}
+	record := result.(*geoRecord)
+	record.Country.ISOCode = location[0]
+	record.Continent.Code = location[1]
+	return nil
+}
+
+func TestSelectGateways(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/8")
+	_, subnet24, _ := net.ParseCIDR("10.1.2.0/24")
+	s := &gatewaySelector{
+		pools: map[string][]string{
+			"office": {"192.0.2.10"},
+			"lab":    {"192.0.2.20", "2001:db8::20"},
+			"de":     {"192.0.2.30"},
+			"eu":     {"192.0.2.40"},
+		},
+		subnets:  []subnetPool{{subnet: subnet, pool: "office"}, {subnet: subnet24, pool: "lab"}},
+		geo:      fakeGeo{80: {"DE", "EU"}, 81: {"FR", "EU"}, 82: {"US", "NA"}},
+		geoPools: map[string]string{"DE": "de", "EU": "eu"},
+	}
+	fallback := []string{"192.0.2.1"}
+
+	tests := []struct {
+		name    string
+		ip      string
+		qtype   uint16
+		res     string
+		located bool
+	}{
+		{name: "NoClient", res: "192.0.2.1"},
+		{name: "Subnet", ip: "10.9.9.9", qtype: dns.TypeA, res: "192.0.2.10", located: true},
+		{name: "MostSpecificSubnet", ip: "10.1.2.3", qtype: dns.TypeA, res: "192.0.2.20", located: true},
+		{name: "SubnetIPv6", ip: "10.1.2.3", qtype: dns.TypeAAAA, res: "2001:db8::20", located: true},
+		{name: "NoAddressesOfType", ip: "10.9.9.9", qtype: dns.TypeAAAA, res: "192.0.2.1", located: true},
+		{name: "Country", ip: "80.1.1.1", qtype: dns.TypeA, res: "192.0.2.30", located: true},
+		{name: "Continent", ip: "81.1.1.1", qtype: dns.TypeA, res: "192.0.2.40", located: true},
+		{name: "UnknownLocation", ip: "82.1.1.1", qtype: dns.TypeA, res: "192.0.2.1", located: true},
+		{name: "NotFound", ip: "83.1.1.1", qtype: dns.TypeA, res: "192.0.2.1", located: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			var c *client
+			if test.ip != "" {
+				c = &client{ip: net.ParseIP(test.ip)}
+				ctx = withClient(ctx, c)
+			}
+			res := s.selectGateways(ctx, test.qtype, fallback)
+			if strings.Join(res, ",") != test.res {
+				t.Fatalf("Unexpected gateways %v (expected %s)", res, test.res)
+			}
+			if c != nil && c.located != test.located {
+				t.Fatalf("Unexpected located %t", c.located)
+			}
+		})
+	}
+
+	// A missing selector uses the fallback.
+	var none *gatewaySelector
+	if res := none.selectGateways(context.Background(), dns.TypeA, fallback); strings.Join(res, ",") != "192.0.2.1" {
+		t.Fatalf("Unexpected gateways %v", res)
+	}
+}
+
+func TestClientSubnet(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("wealdtech.eth.", dns.TypeA)
+	state := request.Request{W: &test.ResponseWriter{}, Req: r}
+	if c := newClient(state); c.subnet != nil || c.ip.String() != "10.240.0.1" {
+		t.Fatalf("Unexpected client %v", c.ip)
+	}
+
+	r.SetEdns0(4096, false)
+	r.IsEdns0().Option = append(r.IsEdns0().Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: 24,
+		Address:       net.ParseIP("198.51.100.0"),
+	})
+	c := newClient(state)
+	if c.subnet == nil || c.ip.String() != "198.51.100.0" {
+		t.Fatalf("Unexpected client %v", c.ip)
+	}
+
+	a := new(dns.Msg)
+	a.SetReply(r)
+	state.SizeAndDo(a)
+	c.setScope(a)
+	if scope := a.IsEdns0().Option[0].(*dns.EDNS0_SUBNET).SourceScope; scope != 0 {
+		t.Fatalf("Unexpected scope %d for unlocated answer", scope)
+	}
+	c.located = true
+	c.setScope(a)
+	if scope := a.IsEdns0().Option[0].(*dns.EDNS0_SUBNET).SourceScope; scope != 24 {
+		t.Fatalf("Unexpected scope %d", scope)
+	}
+}
+
+// closingGeo is a location database that records being closed.
+type closingGeo struct {
+	fakeGeo
+	closed bool
+}
+
+func (c *closingGeo) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestGatewaySelectorClose(t *testing.T) {
+	geo := &closingGeo{fakeGeo: fakeGeo{}}
+	s := &gatewaySelector{geo: geo}
+	if err := s.close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !geo.closed {
+		t.Fatalf("Location database not closed")
+	}
+	if err := (*gatewaySelector)(nil).close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestServeDNSNoDataScope(t *testing.T) {
+	// No owner, so no data.
+	backend := &mockBackend{result: addressResult(common.Address{})}
+	registry, err := ens.NewRegistry(backend)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	e := ENS{
+		Next:     test.NextHandler(dns.RcodeRefused, nil),
+		Backend:  backend,
+		Registry: registry,
+		Zones:    []string{"eth."},
+	}
+
+	r := new(dns.Msg)
+	r.SetQuestion("nodata.eth.", dns.TypeA)
+	r.SetEdns0(4096, false)
+	r.IsEdns0().Option = append(r.IsEdns0().Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: 24,
+		Address:       net.ParseIP("198.51.100.0"),
+	})
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := e.ServeDNS(context.Background(), rec, r); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rec.Msg.Answer) != 0 {
+		t.Fatalf("Unexpected answer %v", rec.Msg.Answer)
+	}
+	opt := rec.Msg.IsEdns0()
+	if opt == nil || len(opt.Option) != 1 {
+		t.Fatalf("Client subnet not returned")
+	}
+	if subnet, isSubnet := opt.Option[0].(*dns.EDNS0_SUBNET); !isSubnet || subnet.SourceScope != 0 {
+		t.Fatalf("Unexpected option %v", opt.Option[0])
+	}
+}
@@ -7,7 +7,14 @@ require (
 	github.com/coredns/coredns v1.6.1
 	github.com/ethereum/go-ethereum v1.10.17
 	github.com/hashicorp/golang-lru v0.5.5-0.20210104140557-80c98217689d
-	github.com/labstack/gommon v0.3.0
-	github.com/miekg/dns v1.1.25
+	github.com/ipfs/go-cid v0.0.7
+	github.com/miekg/dns v1.1.43
+	github.com/mr-tron/base58 v1.2.0
+	github.com/multiformats/go-multibase v0.0.3
+	github.com/multiformats/go-multihash v0.0.15
+	github.com/opentracing/opentracing-go v1.1.0
+	github.com/oschwald/maxminddb-golang v1.8.0
+	github.com/prometheus/client_golang v1.0.0
 	github.com/wealdtech/go-ens/v3 v3.5.0
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 )
@@ -0,0 +1,170 @@
+package ens
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// defaultMaxBlockLag is the default maximum age of the latest block for the
+// plugin to be considered ready.
+const defaultMaxBlockLag = 2 * time.Minute
+
+// healthCheckTimeout is the maximum time allowed for a health check.
+const healthCheckTimeout = 5 * time.Second
+
+// syncProgresser reports the sync progress of an Ethereum node.
+type syncProgresser interface {
+	SyncProgress(ctx context.Context) (*ethereum.SyncProgress, error)
+}
+
+// HealthReport is a report on the state of the Ethereum node used by the plugin.
+type HealthReport struct {
+	// Syncing is true if the node reports that it is syncing.
+	Syncing bool
+	// BlockNumber is the number of the latest block known to the node.
+	BlockNumber uint64
+	// BlockLag is the age of the latest block known to the node.
+	BlockLag time.Duration
+	// MaxBlockLag is the maximum acceptable age of the latest block.
+	MaxBlockLag time.Duration
+	// RegistryHasCode is true if the ENS registry contract has code.
+	RegistryHasCode bool
+	// Errors are the errors encountered when checking the node.
+	Errors []error
+}
+
+// Healthy returns true if the node is in a state to serve requests.
+func (h *HealthReport) Healthy() bool {
+	return len(h.Errors) == 0 &&
+		!h.Syncing &&
+		h.BlockLag <= h.MaxBlockLag &&
+		h.RegistryHasCode
+}
+
+// String returns a description of the report.
+func (h *HealthReport) String() string {
+	parts := []string{
+		fmt.Sprintf("syncing=%t", h.Syncing),
+		fmt.Sprintf("block=%d", h.BlockNumber),
+		fmt.Sprintf("lag=%v", h.BlockLag.Truncate(time.Second)),
+		fmt.Sprintf("max_lag=%v", h.MaxBlockLag),
+		fmt.Sprintf("registry_code=%t", h.RegistryHasCode),
+	}
+	for _, err := range h.Errors {
+		parts = append(parts, fmt.Sprintf("error=%q", err.Error()))
+	}
+	return strings.Join(parts, " ")
+}
+
+// checkHealth checks the state of the Ethereum node.
+func checkHealth(ctx context.Context, syncer syncProgresser, backend bind.ContractBackend, registry common.Address, maxBlockLag time.Duration) *HealthReport {
+	report := &HealthReport{MaxBlockLag: maxBlockLag}
+
+	progress, err := syncer.SyncProgress(ctx)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Errorf("failed to obtain sync progress: %v", err))
+	} else {
+		report.Syncing = progress != nil
+	}
+
+	header, err := backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Errorf("failed to obtain latest block: %v", err))
+	} else {
+		report.BlockNumber = header.Number.Uint64()
+		report.BlockLag = time.Since(time.Unix(int64(header.Time), 0))
+	}
+
+	code, err := backend.CodeAt(ctx, registry, nil)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Errorf("failed to obtain registry code: %v", err))
+	} else {
+		report.RegistryHasCode = len(code) > 0
+	}
+
+	return report
+}
+
+// healthInterval is the interval between health checks of the Ethereum
+// node.  A report is reused until it is this old, so polls from the ready
+// plugin do not each call the node.
+const healthInterval = 15 * time.Second
+
+// healthMonitor checks the state of the Ethereum node, caches the report and
+// exports it as metrics.
+type healthMonitor struct {
+	syncer      syncProgresser
+	backend     bind.ContractBackend
+	registry    common.Address
+	maxBlockLag time.Duration
+
+	mu      sync.Mutex
+	last    *HealthReport
+	checked time.Time
+}
+
+// newHealthMonitor creates a monitor for the given node and registry.
+func newHealthMonitor(syncer syncProgresser, backend bind.ContractBackend, registry common.Address, maxBlockLag time.Duration) *healthMonitor {
+	return &healthMonitor{
+		syncer:      syncer,
+		backend:     backend,
+		registry:    registry,
+		maxBlockLag: maxBlockLag,
+	}
+}
+
+// report returns the latest report, checking the node if the report is
+// older than healthInterval.
+func (m *healthMonitor) report() *HealthReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.last != nil && time.Since(m.checked) < healthInterval {
+		return m.last
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	m.last = checkHealth(ctx, m.syncer, m.backend, m.registry, m.maxBlockLag)
+	m.checked = time.Now()
+	recordHealth(m.last)
+	return m.last
+}
+
+// run checks the node every healthInterval until stopped, so the metrics
+// stay current whether or not the ready plugin is polling.
+func (m *healthMonitor) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+	for {
+		m.report()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Health returns a report on the state of the Ethereum node used by the
+// plugin.  The report may be up to healthInterval old.
+func (e ENS) Health() *HealthReport {
+	return e.Monitor.report()
+}
+
+// Ready returns true if we're ready to serve DNS records i.e. our chain is
+// synced, recent and contains the ENS registry.  It is called by the ready
+// plugin; if not ready the reason is logged.
+func (e ENS) Ready() bool {
+	report := e.Health()
+	if !report.Healthy() {
+		log.Warningf("not ready: %v", report)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package ens
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// mockNode is an Ethereum node with a configurable state.
+type mockNode struct {
+	bind.ContractBackend
+	syncing   bool
+	syncErr   error
+	blockTime time.Time
+	code      []byte
+	checks    int32
+}
+
+func (m *mockNode) SyncProgress(ctx context.Context) (*ethereum.SyncProgress, error) {
+	if m.syncErr != nil {
+		return nil, m.syncErr
+	}
+	if m.syncing {
+		return &ethereum.SyncProgress{}, nil
+	}
+	return nil, nil
+}
+
+func (m *mockNode) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	atomic.AddInt32(&m.checks, 1)
+	return &types.Header{Number: big.NewInt(100), Time: uint64(m.blockTime.Unix())}, nil
+}
+
+func (m *mockNode) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return m.code, nil
+}
+
+func TestCheckHealth(t *testing.T) {
+	tests := []struct {
+		node    *mockNode
+		healthy bool
+	}{
+		{&mockNode{blockTime: time.Now(), code: []byte{0x01}}, true},
+		{&mockNode{blockTime: time.Now().Add(-time.Hour), code: []byte{0x01}}, false},
+		{&mockNode{syncing: true, blockTime: time.Now(), code: []byte{0x01}}, false},
+		{&mockNode{syncErr: errors.New("failed"), blockTime: time.Now(), code: []byte{0x01}}, false},
+		{&mockNode{blockTime: time.Now()}, false},
+	}
+
+	for i, tt := range tests {
+		report := checkHealth(context.Background(), tt.node, tt.node, common.Address{}, time.Minute)
+		if report.Healthy() != tt.healthy {
+			t.Errorf("Test %d: healthy %t (expected %t); report %v", i, report.Healthy(), tt.healthy, report)
+		}
+	}
+}
+
+func TestHealthMonitor(t *testing.T) {
+	node := &mockNode{syncing: true, blockTime: time.Now(), code: []byte{0x01}}
+	e := ENS{Monitor: newHealthMonitor(node, node, common.Address{}, time.Minute)}
+
+	if e.Ready() {
+		t.Errorf("ready while syncing")
+	}
+	if testutil.ToFloat64(healthy) != 0 || testutil.ToFloat64(nodeSyncing) != 1 {
+		t.Errorf("health metrics not recorded: healthy %v, syncing %v", testutil.ToFloat64(healthy), testutil.ToFloat64(nodeSyncing))
+	}
+	if testutil.ToFloat64(blockNumber) != 100 {
+		t.Errorf("block number %v (expected 100)", testutil.ToFloat64(blockNumber))
+	}
+
+	// Repeated polls within the interval reuse the report.
+	node.syncing = false
+	for i := 0; i < 5; i++ {
+		if e.Ready() {
+			t.Errorf("cached report not used")
+		}
+	}
+	if checks := atomic.LoadInt32(&node.checks); checks != 1 {
+		t.Errorf("node checked %d times (expected 1)", checks)
+	}
+
+	// A stale report is refreshed.
+	e.Monitor.checked = time.Now().Add(-healthInterval)
+	if !e.Ready() {
+		t.Errorf("not ready after refresh: %v", e.Health())
+	}
+	if testutil.ToFloat64(healthy) != 1 || testutil.ToFloat64(nodeSyncing) != 0 || testutil.ToFloat64(registryHasCode) != 1 {
+		t.Errorf("health metrics not updated")
+	}
+	if checks := atomic.LoadInt32(&node.checks); checks != 2 {
+		t.Errorf("node checked %d times (expected 2)", checks)
+	}
+}
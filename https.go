@@ -0,0 +1,98 @@
+package ens
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// handleHTTPS answers HTTPS and SVCB requests for a domain with a contenthash.
+// Records held on-chain are used if present, otherwise a record is synthesized
+// that directs clients to the gateways for the contenthash's codec.
+func (e ENS) handleHTTPS(ctx context.Context, name string, domain string, contentHash []byte, qtype uint16) ([]dns.RR, error) {
+	results := make([]dns.RR, 0)
+
+	rrSet, err := e.obtainRRSet(ctx, name, domain, qtype)
+	if err == nil && len(rrSet) != 0 {
+		// We have an on-chain rrset; use it
+		results, err = unpackRRs(rrSet)
+		if err != nil {
+			log.Warningf("invalid %s records for %s: %v", dns.TypeToString[qtype], name, err)
+		}
+		return inBailiwick(results, name, qtype), nil
+	}
+
+	if cname := e.gatewayCNAME(ctx, name, domain, contentHash); cname != nil {
+		// The subdomain gateway provides its own HTTPS records.
+		return append(results, cname), nil
+	}
+	codec, err := contenthashCodec(contentHash)
+	if err != nil {
+		return results, nil
+	}
+	if result := e.gatewaySVCB(ctx, name, domain, qtype, codec); result != nil {
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// gatewaySVCB creates an HTTPS or SVCB record for the gateways of a
+// contenthash codec.  The record's target is the name itself, which resolves
+// to the gateway addresses.  nil is returned if there are no gateways for the
+// codec.
+func (e ENS) gatewaySVCB(ctx context.Context, name string, domain string, qtype uint16, codec string) dns.RR {
+	as := e.gatewayAddresses(ctx, codec, dns.TypeA)
+	aaaas := e.gatewayAddresses(ctx, codec, dns.TypeAAAA)
+	if len(as) == 0 && len(aaaas) == 0 {
+		return nil
+	}
+
+	svcb := dns.SVCB{
+		Hdr:      dns.RR_Header{Name: name, Rrtype: qtype, Class: dns.ClassINET, Ttl: e.ttl(ctx, domain, qtype)},
+		Priority: 1,
+		Target:   ".",
+	}
+	if len(e.HTTPSALPN) > 0 {
+		svcb.Value = append(svcb.Value, &dns.SVCBAlpn{Alpn: e.HTTPSALPN})
+	}
+	if e.HTTPSPort != 0 {
+		svcb.Value = append(svcb.Value, &dns.SVCBPort{Port: e.HTTPSPort})
+	}
+	if e.HTTPSHints {
+		if hints := parseIPs(as); len(hints) > 0 {
+			svcb.Value = append(svcb.Value, &dns.SVCBIPv4Hint{Hint: hints})
+		}
+		if hints := parseIPs(aaaas); len(hints) > 0 {
+			svcb.Value = append(svcb.Value, &dns.SVCBIPv6Hint{Hint: hints})
+		}
+	}
+
+	if qtype == dns.TypeHTTPS {
+		return &dns.HTTPS{SVCB: svcb}
+	}
+	return &svcb
+}
+
+// parseIPs parses a list of addresses, ignoring any that are invalid.
+func parseIPs(addresses []string) []net.IP {
+	ips := make([]net.IP, 0, len(addresses))
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// obtainRRSet obtains the on-chain wire-format RRset of a name.
+func (e ENS) obtainRRSet(ctx context.Context, name string, domain string, qtype uint16) ([]byte, error) {
+	ethDomain := strings.TrimSuffix(domain, ".")
+	resolver, err := e.getDNSResolver(ctx, ethDomain)
+	if err != nil {
+		return []byte{}, nil
+	}
+
+	return dnsRecord(ctx, resolver, ethDomain, name, qtype)
+}
@@ -0,0 +1,71 @@
+package ens
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestGatewaySVCB(t *testing.T) {
+	gatewayAs := map[string][]string{"ipfs-ns": {"176.9.154.81", "176.9.154.82"}}
+	gatewayAAAAs := map[string][]string{"ipfs-ns": {"2a01:4f8:160:4069::2"}, "swarm-ns": {"2a01:4f8:160:4069::3"}}
+
+	tests := []struct {
+		name   string
+		e      ENS
+		qtype  uint16
+		codec  string
+		result string
+	}{
+		{
+			name:   "Plain",
+			e:      ENS{GatewayAs: gatewayAs, GatewayAAAAs: gatewayAAAAs},
+			qtype:  dns.TypeHTTPS,
+			codec:  "ipfs-ns",
+			result: "wealdtech.eth.\t3600\tIN\tHTTPS\t1 .",
+		},
+		{
+			name:   "Parameters",
+			e:      ENS{GatewayAs: gatewayAs, GatewayAAAAs: gatewayAAAAs, HTTPSALPN: []string{"h2", "http/1.1"}, HTTPSPort: 8443, HTTPSHints: true},
+			qtype:  dns.TypeHTTPS,
+			codec:  "ipfs-ns",
+			result: "wealdtech.eth.\t3600\tIN\tHTTPS\t1 . alpn=\"h2,http/1.1\" port=\"8443\" ipv4hint=\"176.9.154.81,176.9.154.82\" ipv6hint=\"2a01:4f8:160:4069::2\"",
+		},
+		{
+			name:   "IPv6Only",
+			e:      ENS{GatewayAs: gatewayAs, GatewayAAAAs: gatewayAAAAs, HTTPSHints: true},
+			qtype:  dns.TypeSVCB,
+			codec:  "swarm-ns",
+			result: "wealdtech.eth.\t3600\tIN\tSVCB\t1 . ipv6hint=\"2a01:4f8:160:4069::3\"",
+		},
+		{
+			name:  "NoGateway",
+			e:     ENS{GatewayAs: gatewayAs, GatewayAAAAs: gatewayAAAAs},
+			qtype: dns.TypeHTTPS,
+			codec: "arweave-ns",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := test.e.gatewaySVCB(context.Background(), "wealdtech.eth.", "wealdtech.eth.", test.qtype, test.codec)
+			if test.result == "" {
+				if rr != nil {
+					t.Fatalf("Unexpected record %v", rr)
+				}
+				return
+			}
+			if rr == nil {
+				t.Fatalf("No record returned")
+			}
+			if rr.String() != test.result {
+				t.Fatalf("Unexpected record %q (expected %q)", rr.String(), test.result)
+			}
+			// Ensure that the record can be sent.
+			if _, err := dns.PackRR(rr, make([]byte, 512), 0, nil, false); err != nil {
+				t.Fatalf("Failed to pack record: %v", err)
+			}
+		})
+	}
+}
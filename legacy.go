@@ -0,0 +1,94 @@
+package ens
+
+import (
+	"context"
+	"encoding/binary"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ens "github.com/wealdtech/go-ens/v3"
+)
+
+// legacyResolverABI is the part of the resolver ABI for the content records
+// that predate contenthash (EIP-1577).
+const legacyResolverABI = `[{"constant":true,"inputs":[{"name":"node","type":"bytes32"}],"name":"content","outputs":[{"name":"","type":"bytes32"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[{"name":"node","type":"bytes32"}],"name":"multihash","outputs":[{"name":"","type":"bytes"}],"payable":false,"stateMutability":"view","type":"function"}]`
+
+var parsedLegacyResolverABI abi.ABI
+
+func init() {
+	var err error
+	parsedLegacyResolverABI, err = abi.JSON(strings.NewReader(legacyResolverABI))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Multicodec IDs used to build contenthashes from legacy records.
+const (
+	codecIPFS          = 0xe3
+	codecSwarm         = 0xe4
+	codecDagPB         = 0x70
+	codecSwarmManifest = 0xfa
+	multihashKeccak256 = 0x1b
+)
+
+// legacyContenthash obtains the content of a domain from the legacy
+// multihash or content records of its resolver, translated to the equivalent
+// contenthash.  The multihash record holds an IPFS multihash and the content
+// record holds a Swarm hash.  An empty contenthash is returned if neither is
+// set.
+func (e ENS) legacyContenthash(ctx context.Context, info *resolverInfo, domain string) ([]byte, error) {
+	nameHash, err := ens.NameHash(domain)
+	if err != nil {
+		return nil, err
+	}
+	contract := bind.NewBoundContract(info.address, parsedLegacyResolverABI, e.Backend, nil, nil)
+
+	if info.capabilities.supports(capMultihash) {
+		var out []interface{}
+		if err := contract.Call(callOpts(ctx), &out, "multihash", nameHash); err != nil {
+			return nil, err
+		}
+		multihash := *abi.ConvertType(out[0], new([]byte)).(*[]byte)
+		if len(multihash) > 0 {
+			return multihashToContenthash(multihash), nil
+		}
+	}
+
+	if info.capabilities.supports(capContent) {
+		var out []interface{}
+		if err := contract.Call(callOpts(ctx), &out, "content", nameHash); err != nil {
+			return nil, err
+		}
+		content := *abi.ConvertType(out[0], new([32]byte)).(*[32]byte)
+		if content != [32]byte{} {
+			return swarmHashToContenthash(content), nil
+		}
+	}
+
+	return []byte{}, nil
+}
+
+// multihashToContenthash translates an IPFS multihash to an ipfs-ns
+// contenthash holding the equivalent CIDv1.
+func multihashToContenthash(multihash []byte) []byte {
+	res := appendUvarints(nil, codecIPFS, 1, codecDagPB)
+	return append(res, multihash...)
+}
+
+// swarmHashToContenthash translates a Swarm hash to a swarm-ns contenthash.
+func swarmHashToContenthash(hash [32]byte) []byte {
+	res := appendUvarints(nil, codecSwarm, 1, codecSwarmManifest, multihashKeccak256, uint64(len(hash)))
+	return append(res, hash[:]...)
+}
+
+// appendUvarints appends values encoded as unsigned varints.
+func appendUvarints(buf []byte, values ...uint64) []byte {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	for _, value := range values {
+		size := binary.PutUvarint(tmp, value)
+		buf = append(buf, tmp[:size]...)
+	}
+	return buf
+}
@@ -0,0 +1,93 @@
+package ens
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	ens "github.com/wealdtech/go-ens/v3"
+)
+
+// abiBytes encodes a value as the ABI output of a function returning bytes.
+func abiBytes(value []byte) []byte {
+	res := abiWords(32, len(value))
+	return append(res, common.RightPadBytes(value, (len(value)+31)/32*32)...)
+}
+
+func TestLegacyContenthash(t *testing.T) {
+	multihash, _ := hex.DecodeString("122029f2d17be6139079dc48696d1f582a8530eb9805b561eda517e22a892c7e3f1f")
+	swarmHash := common.HexToHash("0xd1de9994b4d039f6548d191eb26786769f580809256b4685ef316805265ea162")
+
+	tests := []struct {
+		name         string
+		capabilities capabilities
+		multihash    []byte
+		content      common.Hash
+		res          string
+	}{
+		{
+			name:         "Multihash",
+			capabilities: capMultihash,
+			multihash:    multihash,
+			res:          "ipfs://QmRAQB6YaCyidP37UdDnjFY5vQuiBrcqdyoW1CuDgwxkD4",
+		},
+		{
+			name:         "Content",
+			capabilities: capContent,
+			content:      swarmHash,
+			res:          "bzz://d1de9994b4d039f6548d191eb26786769f580809256b4685ef316805265ea162",
+		},
+		{
+			name:         "MultihashPreferred",
+			capabilities: capMultihash | capContent,
+			multihash:    multihash,
+			content:      swarmHash,
+			res:          "ipfs://QmRAQB6YaCyidP37UdDnjFY5vQuiBrcqdyoW1CuDgwxkD4",
+		},
+		{
+			name:         "EmptyMultihash",
+			capabilities: capMultihash | capContent,
+			content:      swarmHash,
+			res:          "bzz://d1de9994b4d039f6548d191eb26786769f580809256b4685ef316805265ea162",
+		},
+		{
+			name:         "Empty",
+			capabilities: capMultihash | capContent,
+		},
+		{
+			name:         "Unsupported",
+			capabilities: capAddr,
+			multihash:    multihash,
+			content:      swarmHash,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := &methodBackend{results: map[string][]byte{
+				"multihash(bytes32)": abiBytes(test.multihash),
+				"content(bytes32)":   test.content.Bytes(),
+			}}
+			info := &resolverInfo{
+				address:      common.HexToAddress("0x5FfC014343cd971B7eb70732021E26C35B744cc4"),
+				capabilities: test.capabilities,
+			}
+			res, err := ENS{Backend: backend}.legacyContenthash(context.Background(), info, "legacy.eth")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var expected []byte
+			if test.res != "" {
+				expected, err = ens.StringToContenthash(test.res)
+				if err != nil {
+					t.Fatalf("Failed to create contenthash: %v", err)
+				}
+			}
+			if !bytes.Equal(res, expected) {
+				t.Fatalf("Unexpected contenthash %x (expected %x)", res, expected)
+			}
+		})
+	}
+}
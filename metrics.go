@@ -0,0 +1,227 @@
+package ens
+
+import (
+	"context"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// lookupCount is the number of lookups by result.
+	lookupCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "lookups_total",
+		Help:      "Counter of ENS lookups by result.",
+	}, []string{"server", "result"})
+
+	// queryCount is the number of requests by the path used to answer them.
+	queryCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "queries_total",
+		Help:      "Counter of requests by the path used to answer them.",
+	}, []string{"path"})
+
+	// blockedCount is the number of requests for blocked names.
+	blockedCount = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "blocked_total",
+		Help:      "Counter of requests for names blocked by policy.",
+	})
+
+	// rpcCount is the number of calls to the Ethereum node by method.
+	rpcCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "rpc_calls_total",
+		Help:      "Counter of calls to the Ethereum node by method.",
+	}, []string{"method"})
+
+	// rpcErrorCount is the number of failed calls to the Ethereum node by method.
+	rpcErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "rpc_errors_total",
+		Help:      "Counter of failed calls to the Ethereum node by method.",
+	}, []string{"method"})
+
+	// rpcSharedCount is the number of calls to the Ethereum node that were
+	// avoided by sharing the result of an identical call in flight.
+	rpcSharedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "rpc_shared_total",
+		Help:      "Counter of calls to the Ethereum node answered by an identical call in flight.",
+	}, []string{"method"})
+
+	// rpcDuration is the time taken for calls to the Ethereum node by method.
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "rpc_duration_seconds",
+		Buckets:   plugin.TimeBuckets,
+		Help:      "Histogram of the time taken for calls to the Ethereum node by method.",
+	}, []string{"method"})
+
+	// cacheHits is the number of cache hits by cache.
+	cacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "cache_hits_total",
+		Help:      "Counter of cache hits by cache.",
+	}, []string{"cache"})
+
+	// cacheMisses is the number of cache misses by cache.
+	cacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "cache_misses_total",
+		Help:      "Counter of cache misses by cache.",
+	}, []string{"cache"})
+
+	// cacheEvictions is the number of cache evictions by cache.
+	cacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "cache_evictions_total",
+		Help:      "Counter of cache evictions by cache.",
+	}, []string{"cache"})
+
+	// gatewayHealthy is the health of each gateway address.
+	gatewayHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "gateway_healthy",
+		Help:      "Gauge of the health of each gateway address; 1 if healthy, otherwise 0.",
+	}, []string{"address"})
+
+	// gatewayProbeFailures is the number of failed probes of each gateway address.
+	gatewayProbeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "gateway_probe_failures_total",
+		Help:      "Counter of failed health probes by gateway address.",
+	}, []string{"address"})
+
+	// blockLag is the age of the latest block known to the Ethereum node.
+	blockLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "block_lag_seconds",
+		Help:      "Gauge of the age of the latest block known to the Ethereum node.",
+	})
+
+	// blockNumber is the number of the latest block known to the Ethereum node.
+	blockNumber = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "block_number",
+		Help:      "Gauge of the number of the latest block known to the Ethereum node.",
+	})
+
+	// healthy is the overall health of the Ethereum node.
+	healthy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "healthy",
+		Help:      "Gauge of the health of the Ethereum node; 1 if ready to serve requests, otherwise 0.",
+	})
+
+	// nodeSyncing is whether the Ethereum node is syncing.
+	nodeSyncing = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "node_syncing",
+		Help:      "Gauge of whether the Ethereum node is syncing; 1 if syncing, otherwise 0.",
+	})
+
+	// registryHasCode is whether the ENS registry contract has code.
+	registryHasCode = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "registry_has_code",
+		Help:      "Gauge of whether the ENS registry contract has code; 1 if it has, otherwise 0.",
+	})
+
+	// healthErrors is the number of errors in the latest health check.
+	healthErrors = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "ens",
+		Name:      "health_errors",
+		Help:      "Gauge of the number of errors encountered by the latest health check of the Ethereum node.",
+	})
+)
+
+// registerMetrics registers the metrics of the plugin with the prometheus
+// plugin, if it is enabled for the server block.
+func registerMetrics(c *caddy.Controller) {
+	metrics.MustRegister(c,
+		lookupCount, queryCount, blockedCount,
+		rpcCount, rpcErrorCount, rpcSharedCount, rpcDuration,
+		cacheHits, cacheMisses, cacheEvictions,
+		gatewayHealthy, gatewayProbeFailures,
+		blockLag, blockNumber, healthy, nodeSyncing, registryHasCode, healthErrors)
+}
+
+// recordHealth exports a health report as metrics.
+func recordHealth(report *HealthReport) {
+	healthy.Set(boolGauge(report.Healthy()))
+	nodeSyncing.Set(boolGauge(report.Syncing))
+	registryHasCode.Set(boolGauge(report.RegistryHasCode))
+	healthErrors.Set(float64(len(report.Errors)))
+	if report.BlockNumber != 0 {
+		blockNumber.Set(float64(report.BlockNumber))
+		blockLag.Set(report.BlockLag.Seconds())
+	}
+}
+
+// boolGauge returns the value of a gauge for a boolean; 1 if true, otherwise 0.
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// pathKey is the context key for the path of a request.
+type pathKey struct{}
+
+// requestPath is the path used to answer a request.  A lookup queries ENS
+// for other types as it follows CNAME and DNAME records and adds glue, so
+// only queries for the type of the request decide its path.
+type requestPath struct {
+	qtype uint16
+	path  string
+}
+
+// withRequestPath returns a context that carries the given request path.
+func withRequestPath(ctx context.Context, p *requestPath) context.Context {
+	return context.WithValue(ctx, pathKey{}, p)
+}
+
+// requestPathFrom returns the request path in the context, or nil if there
+// is none.
+func requestPathFrom(ctx context.Context) *requestPath {
+	p, _ := ctx.Value(pathKey{}).(*requestPath)
+	return p
+}
+
+// recordPath records the path used to answer a query of the given type.
+func recordPath(ctx context.Context, qtype uint16, path string) {
+	if p := requestPathFrom(ctx); p != nil && p.qtype == qtype {
+		p.path = path
+	}
+	queryLogEntryFrom(ctx).addPath(path)
+}
+
+// finish counts the request by the path used to answer it, if any.
+func (p *requestPath) finish() {
+	if p.path != "" {
+		queryCount.WithLabelValues(p.path).Inc()
+	}
+}
@@ -0,0 +1,63 @@
+package ens
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRegisterMetrics(t *testing.T) {
+	c := caddy.NewTestController("dns", `ens`)
+	met := metrics.New("localhost:0")
+	cfg := dnsserver.GetConfig(c)
+	cfg.AddPlugin(func(next plugin.Handler) plugin.Handler { return met })
+	// Creating the server registers its handlers, as at startup.
+	if _, err := dnsserver.NewServer("dns://:53", []*dnsserver.Config{cfg}); err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	registerMetrics(c)
+	lookupCount.WithLabelValues("dns://:53", Success.String()).Inc()
+	rpcCount.WithLabelValues("eth_call").Inc()
+	blockLag.Set(1)
+
+	families, err := met.Reg.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	for _, name := range []string{"coredns_ens_lookups_total", "coredns_ens_rpc_calls_total", "coredns_ens_block_lag_seconds"} {
+		if !names[name] {
+			t.Fatalf("Metric %s not exported", name)
+		}
+	}
+}
+
+func TestRequestPath(t *testing.T) {
+	before := testutil.ToFloat64(queryCount.WithLabelValues("contenthash"))
+	beforeProbes := testutil.ToFloat64(queryCount.WithLabelValues("dnsresolver"))
+
+	path := &requestPath{qtype: dns.TypeA}
+	ctx := withRequestPath(context.Background(), path)
+	// Queries made while following CNAME and DNAME records do not count.
+	recordPath(ctx, dns.TypeDNAME, "dnsresolver")
+	recordPath(ctx, dns.TypeCNAME, "dnsresolver")
+	recordPath(ctx, dns.TypeA, "contenthash")
+	path.finish()
+
+	if count := testutil.ToFloat64(queryCount.WithLabelValues("contenthash")); count != before+1 {
+		t.Fatalf("Unexpected contenthash count %v", count-before)
+	}
+	if count := testutil.ToFloat64(queryCount.WithLabelValues("dnsresolver")); count != beforeProbes {
+		t.Fatalf("Unexpected dnsresolver count %v", count-beforeProbes)
+	}
+}
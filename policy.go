@@ -0,0 +1,351 @@
+package ens
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/request"
+	"github.com/ethereum/go-ethereum/common"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/miekg/dns"
+	ens "github.com/wealdtech/go-ens/v3"
+)
+
+// defaultBlocklistReload is the default interval at which blocklist files are
+// checked for changes.
+const defaultBlocklistReload = time.Minute
+
+// policyCacheTTL is the time for which the owners and resolvers of names are
+// cached for checks against the blocklist.
+const policyCacheTTL = time.Minute
+
+// blockAction is the action taken for a request for a blocked name.
+type blockAction int
+
+const (
+	// blockNXDomain answers with NXDOMAIN.
+	blockNXDomain blockAction = iota
+	// blockRefuse answers with REFUSED.
+	blockRefuse
+	// blockSinkhole answers A and AAAA requests with the sinkhole addresses.
+	blockSinkhole
+)
+
+// blocklist is a set of blocked ENS names, namehashes, owners and resolvers.
+type blocklist struct {
+	names      map[string]bool
+	nameHashes map[[32]byte]bool
+	owners     map[common.Address]bool
+	resolvers  map[common.Address]bool
+}
+
+func newBlocklist() *blocklist {
+	return &blocklist{
+		names:      make(map[string]bool),
+		nameHashes: make(map[[32]byte]bool),
+		owners:     make(map[common.Address]bool),
+		resolvers:  make(map[common.Address]bool),
+	}
+}
+
+// parse adds the entries read from a blocklist.  Each line holds a single
+// entry, either a bare ENS name or namehash or one of "name <name>",
+// "namehash <namehash>", "owner <address>" or "resolver <address>".
+// Anything after a '#' is a comment.
+func (b *blocklist) parse(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		var err error
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			if isNameHash(fields[0]) {
+				err = b.add("namehash", fields[0])
+			} else {
+				err = b.add("name", fields[0])
+			}
+		case 2:
+			err = b.add(strings.ToLower(fields[0]), fields[1])
+		default:
+			err = fmt.Errorf("too many fields")
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// add adds an entry of the given kind.
+func (b *blocklist) add(kind string, value string) error {
+	switch kind {
+	case "name":
+		b.names[dns.Fqdn(strings.ToLower(value))] = true
+	case "namehash":
+		if !isNameHash(value) {
+			return fmt.Errorf("invalid namehash %s", value)
+		}
+		var nameHash [32]byte
+		hex.Decode(nameHash[:], []byte(value[2:]))
+		b.nameHashes[nameHash] = true
+	case "owner", "resolver":
+		if !common.IsHexAddress(value) {
+			return fmt.Errorf("invalid address %s", value)
+		}
+		if kind == "owner" {
+			b.owners[common.HexToAddress(value)] = true
+		} else {
+			b.resolvers[common.HexToAddress(value)] = true
+		}
+	default:
+		return fmt.Errorf("unknown entry type %s", kind)
+	}
+	return nil
+}
+
+// isNameHash returns true if the input is a hex-encoded namehash.
+func isNameHash(input string) bool {
+	if len(input) != 66 || !strings.HasPrefix(input, "0x") {
+		return false
+	}
+	_, err := hex.DecodeString(input[2:])
+	return err == nil
+}
+
+// policy blocks requests for ENS names that match its blocklists.
+type policy struct {
+	files         []string
+	action        blockAction
+	sinkholeAs    []net.IP
+	sinkholeAAAAs []net.IP
+	reload        time.Duration
+
+	mu        sync.RWMutex
+	blocklist *blocklist
+	modTimes  map[string]time.Time
+
+	// owners and resolvers are the cached owners and resolvers of names.
+	owners    *lru.Cache
+	resolvers *lru.Cache
+}
+
+// policyCacheEntry is a cached owner or resolver of a name.
+type policyCacheEntry struct {
+	address common.Address
+	fetched time.Time
+}
+
+// newPolicy creates a policy from the configuration.  Relative paths to
+// blocklist files are relative to root.
+func newPolicy(cfg *config, root string) *policy {
+	files := make([]string, len(cfg.blocklists))
+	for i, file := range cfg.blocklists {
+		if !filepath.IsAbs(file) && root != "" {
+			file = filepath.Join(root, file)
+		}
+		files[i] = file
+	}
+	owners, _ := lru.New(4096)
+	resolvers, _ := lru.New(4096)
+	return &policy{
+		files:         files,
+		action:        cfg.blockAction,
+		sinkholeAs:    cfg.sinkholeAs,
+		sinkholeAAAAs: cfg.sinkholeAAAAs,
+		reload:        cfg.blocklistReload,
+		blocklist:     newBlocklist(),
+		owners:        owners,
+		resolvers:     resolvers,
+	}
+}
+
+// load reads the blocklist files.  If any of them cannot be read the current
+// blocklist is kept.
+func (p *policy) load() error {
+	list := newBlocklist()
+	modTimes := make(map[string]time.Time, len(p.files))
+	for _, file := range p.files {
+		modTime, err := loadBlocklistFile(list, file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = modTime
+	}
+
+	p.mu.Lock()
+	p.blocklist = list
+	p.modTimes = modTimes
+	p.mu.Unlock()
+	log.Infof("loaded blocklist of %d names, %d namehashes, %d owners and %d resolvers",
+		len(list.names), len(list.nameHashes), len(list.owners), len(list.resolvers))
+	return nil
+}
+
+func loadBlocklistFile(list *blocklist, file string) (time.Time, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := list.parse(f); err != nil {
+		return time.Time{}, fmt.Errorf("%s: %v", file, err)
+	}
+	return info.ModTime(), nil
+}
+
+// changed returns true if any of the blocklist files has been modified since
+// it was loaded.
+func (p *policy) changed() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, file := range p.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			// Reload to report the problem.
+			return true
+		}
+		if !info.ModTime().Equal(p.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// run reloads the blocklist files when they change, until stopped.
+func (p *policy) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.reload)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if p.changed() {
+				if err := p.load(); err != nil {
+					log.Errorf("failed to reload blocklist: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// blocked returns the reason that an ENS name is blocked, or "" if it is not.
+// A name is blocked if it or any of its parents is listed by name or
+// namehash, or is owned by a listed owner or uses a listed resolver.  Owners
+// and resolvers are only obtained if they are listed, and are cached.  An
+// error is returned if they cannot be obtained, in which case the name
+// cannot be checked.
+func (p *policy) blocked(ctx context.Context, e ENS, name string) (string, error) {
+	p.mu.RLock()
+	list := p.blocklist
+	p.mu.RUnlock()
+
+	name = dns.Fqdn(strings.ToLower(name))
+	labels := dns.SplitDomainName(name)
+	// Ignore the top-level domain, which is not owned by an individual.
+	for i := 0; i < len(labels)-1; i++ {
+		domain := strings.Join(labels[i:], ".")
+		if list.names[domain+"."] {
+			return fmt.Sprintf("name %s", domain), nil
+		}
+		nameHash, err := ens.NameHash(domain)
+		if err != nil {
+			continue
+		}
+		if list.nameHashes[nameHash] {
+			return fmt.Sprintf("namehash 0x%x", nameHash), nil
+		}
+		if len(list.owners) > 0 {
+			owner, err := cachedAddress(p.owners, domain, func() (common.Address, error) { return e.owner(ctx, domain) })
+			if err != nil {
+				return "", err
+			}
+			if list.owners[owner] {
+				return fmt.Sprintf("owner %s of %s", owner.Hex(), domain), nil
+			}
+		}
+		if len(list.resolvers) > 0 {
+			resolver, err := cachedAddress(p.resolvers, domain, func() (common.Address, error) { return e.resolverAddress(ctx, domain) })
+			if err != nil {
+				return "", err
+			}
+			if list.resolvers[resolver] {
+				return fmt.Sprintf("resolver %s of %s", resolver.Hex(), domain), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// cachedAddress obtains an address for a domain from a cache, fetching and
+// caching it if it is missing or stale.  Failures are not cached.
+func cachedAddress(cache *lru.Cache, domain string, fetch func() (common.Address, error)) (common.Address, error) {
+	if cached, exists := cache.Get(domain); exists {
+		if entry := cached.(*policyCacheEntry); time.Since(entry.fetched) < policyCacheTTL {
+			return entry.address, nil
+		}
+	}
+	address, err := fetch()
+	if err != nil {
+		return ens.UnknownAddress, err
+	}
+	cache.Add(domain, &policyCacheEntry{address: address, fetched: time.Now()})
+	return address, nil
+}
+
+// respond fills in the response to a request for a blocked name, returning the
+// rcode for the plugin to return once the response is written.  Sinkhole
+// records are given the supplied TTL.
+func (p *policy) respond(state request.Request, a *dns.Msg, ttl uint32) int {
+	switch p.action {
+	case blockRefuse:
+		a.Rcode = dns.RcodeRefused
+	case blockSinkhole:
+		a.Rcode = dns.RcodeSuccess
+		var addresses []net.IP
+		switch state.QType() {
+		case dns.TypeA:
+			addresses = p.sinkholeAs
+		case dns.TypeAAAA:
+			addresses = p.sinkholeAAAAs
+		}
+		for _, address := range addresses {
+			hdr := dns.RR_Header{Name: state.QName(), Rrtype: state.QType(), Class: dns.ClassINET, Ttl: ttl}
+			if state.QType() == dns.TypeA {
+				a.Answer = append(a.Answer, &dns.A{Hdr: hdr, A: address})
+			} else {
+				a.Answer = append(a.Answer, &dns.AAAA{Hdr: hdr, AAAA: address})
+			}
+		}
+	default:
+		a.Rcode = dns.RcodeNameError
+	}
+	addExtendedError(state, a, dns.ExtendedErrorCodeBlocked, "")
+	state.SizeAndDo(a)
+	if a.Rcode == dns.RcodeNameError {
+		return dns.RcodeNameError
+	}
+	// REFUSED is a response written by the plugin, so is not returned.
+	return dns.RcodeSuccess
+}
@@ -0,0 +1,296 @@
+package ens
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/miekg/dns"
+	ens "github.com/wealdtech/go-ens/v3"
+)
+
+const testBlocklist = `# Test blocklist
+phish.eth
+0x87e957792abbf42b0eb06623ebcaf40785302256a999fd2e5eff8758e9193169 # wealdtech.eth
+name Scam.ETH
+owner 0x388Ea662EF2c223eC0B047D41Bf3c0f362142ad5
+`
+
+func TestBlocklistParse(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{input: testBlocklist},
+		{input: "resolver 0x388Ea662EF2c223eC0B047D41Bf3c0f362142ad5"},
+		{input: "namehash 0x1234", err: "line 1: invalid namehash 0x1234"},
+		{input: "owner wealdtech.eth", err: "line 1: invalid address wealdtech.eth"},
+		{input: "\nlabel wealdtech", err: "line 2: unknown entry type label"},
+		{input: "name wealdtech.eth extra", err: "line 1: too many fields"},
+	}
+
+	for i, tt := range tests {
+		list := newBlocklist()
+		err := list.parse(strings.NewReader(tt.input))
+		if tt.err == "" && err != nil {
+			t.Errorf("Test %d: unexpected error %v", i, err)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("Test %d: unexpected error %v", i, err)
+		}
+	}
+
+	list := newBlocklist()
+	if err := list.parse(strings.NewReader(testBlocklist)); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(list.names) != 2 || !list.names["phish.eth."] || !list.names["scam.eth."] {
+		t.Errorf("Unexpected names %v", list.names)
+	}
+	if len(list.nameHashes) != 1 || len(list.owners) != 1 || len(list.resolvers) != 0 {
+		t.Errorf("Unexpected entries %v %v %v", list.nameHashes, list.owners, list.resolvers)
+	}
+}
+
+// addressResult is the ABI encoding of an address returned by a contract call.
+func addressResult(address common.Address) []byte {
+	return common.LeftPadBytes(address.Bytes(), 32)
+}
+
+func TestPolicyBlocked(t *testing.T) {
+	blocked := common.HexToAddress("0x388Ea662EF2c223eC0B047D41Bf3c0f362142ad5")
+	other := common.HexToAddress("0x0000000000000000000000000000000000000001")
+
+	tests := []struct {
+		name   string
+		owner  common.Address
+		reason string
+	}{
+		{"phish.eth.", other, "name phish.eth"},
+		{"www.PHISH.eth.", other, "name phish.eth"},
+		{"wealdtech.eth.", other, "namehash 0x87e957792abbf42b0eb06623ebcaf40785302256a999fd2e5eff8758e9193169"},
+		{"sub.wealdtech.eth.", other, "namehash 0x87e957792abbf42b0eb06623ebcaf40785302256a999fd2e5eff8758e9193169"},
+		{"myname.eth.", blocked, "owner 0x388Ea662EF2c223eC0B047D41Bf3c0f362142ad5 of myname.eth"},
+		{"myname.eth.", other, ""},
+		{"eth.", blocked, ""},
+		{"notphish.eth.", other, ""},
+	}
+
+	for _, tt := range tests {
+		backend := &instrumentedBackend{ContractBackend: &mockBackend{result: addressResult(tt.owner)}}
+		registry, err := ens.NewRegistry(backend)
+		if err != nil {
+			t.Fatalf("Failed to create registry: %v", err)
+		}
+		p := newPolicy(&config{}, "")
+		if err := p.blocklist.parse(strings.NewReader(testBlocklist)); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		reason, err := p.blocked(context.Background(), ENS{Backend: backend, Registry: registry}, tt.name)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if reason != tt.reason {
+			t.Errorf("%s: reason %q (expected %q)", tt.name, reason, tt.reason)
+		}
+	}
+}
+
+func TestPolicyBlockedCache(t *testing.T) {
+	mock := &mockBackend{result: addressResult(common.HexToAddress("0x0000000000000000000000000000000000000001"))}
+	backend := &instrumentedBackend{ContractBackend: mock}
+	registry, err := ens.NewRegistry(backend)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	e := ENS{Backend: backend, Registry: registry}
+	p := newPolicy(&config{}, "")
+	if err := p.blocklist.parse(strings.NewReader("owner 0x388Ea662EF2c223eC0B047D41Bf3c0f362142ad5\nresolver 0x388Ea662EF2c223eC0B047D41Bf3c0f362142ad5\n")); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if _, err := p.blocked(context.Background(), e, "www.cached.eth."); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	calls := atomic.LoadInt32(&mock.calls)
+	if calls != 4 {
+		t.Fatalf("Unexpected calls %d", calls)
+	}
+	if _, err := p.blocked(context.Background(), e, "www.cached.eth."); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if atomic.LoadInt32(&mock.calls) != calls {
+		t.Fatalf("Owners and resolvers not cached")
+	}
+
+	// Names that cannot be checked are reported.
+	p = newPolicy(&config{}, "")
+	p.blocklist.add("owner", "0x388Ea662EF2c223eC0B047D41Bf3c0f362142ad5")
+	backend = &instrumentedBackend{ContractBackend: &mockBackend{err: errors.New("header not found")}}
+	registry, err = ens.NewRegistry(backend)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	if _, err := p.blocked(context.Background(), ENS{Backend: backend, Registry: registry}, "failed.eth."); err == nil {
+		t.Fatalf("Expected error not returned")
+	}
+}
+
+func TestServeDNSBlockedCNAME(t *testing.T) {
+	// The name is a CNAME for a blocked name.
+	cname, err := dns.NewRR("blockedcname.eth. 300 IN CNAME phish.eth.")
+	if err != nil {
+		t.Fatalf("Failed to create record: %v", err)
+	}
+	data := make([]byte, 512)
+	n, err := dns.PackRR(cname, data, 0, nil, false)
+	if err != nil {
+		t.Fatalf("Failed to pack record: %v", err)
+	}
+	backend := &methodBackend{results: map[string][]byte{
+		"owner(bytes32)":                    abiWords(common.HexToAddress("0x0000000000000000000000000000000000000001")),
+		"resolver(bytes32)":                 abiWords(common.HexToAddress("0x2B8fC8aF1a0E8A8C2bB1B2bD3F3a7E1B9C4e5D6F")),
+		"supportsInterface(bytes4)":         abiWords(1),
+		"contenthash(bytes32)":              abiBytes(nil),
+		"dnsRecord(bytes32,bytes32,uint16)": abiBytes(data[:n]),
+		"hasDNSRecords(bytes32,bytes32)":    abiWords(1),
+	}}
+	registry, err := ens.NewRegistry(backend)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	p := newPolicy(&config{}, "")
+	if err := p.blocklist.parse(strings.NewReader(testBlocklist)); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	e := ENS{
+		Next:          test.NextHandler(dns.RcodeRefused, nil),
+		Backend:       backend,
+		Registry:      registry,
+		Zones:         []string{"eth."},
+		LookupTimeout: 5 * time.Second,
+		Policy:        p,
+	}
+
+	r := new(dns.Msg)
+	r.SetQuestion("blockedcname.eth.", dns.TypeA)
+	r.SetEdns0(4096, false)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	rcode, err := e.ServeDNS(context.Background(), rec, r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rcode != dns.RcodeNameError || rec.Msg == nil || rec.Msg.Rcode != dns.RcodeNameError || len(rec.Msg.Answer) != 0 {
+		t.Fatalf("Unexpected rcode %d, response %v", rcode, rec.Msg)
+	}
+	opt := rec.Msg.IsEdns0()
+	if opt == nil || len(opt.Option) != 1 || opt.Option[0].(*dns.EDNS0_EDE).InfoCode != dns.ExtendedErrorCodeBlocked {
+		t.Fatalf("Blocked EDE not present in %v", rec.Msg)
+	}
+}
+
+func TestPolicyReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blocklist")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "blocklist")
+	if err := ioutil.WriteFile(file, []byte("phish.eth\n"), 0600); err != nil {
+		t.Fatalf("Failed to write blocklist: %v", err)
+	}
+
+	p := newPolicy(&config{blocklists: []string{"blocklist"}, blocklistReload: 10 * time.Millisecond}, dir)
+	if err := p.load(); err != nil {
+		t.Fatalf("Failed to load blocklist: %v", err)
+	}
+	isBlocked := func(name string) bool {
+		reason, err := p.blocked(context.Background(), ENS{}, name)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		return reason != ""
+	}
+	if !isBlocked("phish.eth.") {
+		t.Fatalf("Name not blocked")
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go p.run(stop)
+
+	// Replace the list, ensuring that the modification time changes.
+	if err := ioutil.WriteFile(file, []byte("scam.eth\n"), 0600); err != nil {
+		t.Fatalf("Failed to write blocklist: %v", err)
+	}
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatalf("Failed to update blocklist: %v", err)
+	}
+	for i := 0; i < 100 && !isBlocked("scam.eth."); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !isBlocked("scam.eth.") {
+		t.Fatalf("Blocklist not reloaded")
+	}
+	if isBlocked("phish.eth.") {
+		t.Fatalf("Name still blocked after reload")
+	}
+
+	// A broken list leaves the current list in place.
+	if err := ioutil.WriteFile(file, []byte("owner nobody\n"), 0600); err != nil {
+		t.Fatalf("Failed to write blocklist: %v", err)
+	}
+	if err := p.load(); err == nil {
+		t.Fatalf("Broken blocklist loaded")
+	}
+	if !isBlocked("scam.eth.") {
+		t.Fatalf("Blocklist replaced by broken list")
+	}
+}
+
+func TestPolicyRespond(t *testing.T) {
+	tests := []struct {
+		policy  *policy
+		qtype   uint16
+		rcode   int
+		msgCode int
+		answers int
+	}{
+		{&policy{action: blockNXDomain}, dns.TypeA, dns.RcodeNameError, dns.RcodeNameError, 0},
+		{&policy{action: blockRefuse}, dns.TypeA, dns.RcodeSuccess, dns.RcodeRefused, 0},
+		{&policy{action: blockSinkhole, sinkholeAs: []net.IP{net.ParseIP("192.0.2.1").To4()}}, dns.TypeA, dns.RcodeSuccess, dns.RcodeSuccess, 1},
+		{&policy{action: blockSinkhole, sinkholeAs: []net.IP{net.ParseIP("192.0.2.1").To4()}}, dns.TypeAAAA, dns.RcodeSuccess, dns.RcodeSuccess, 0},
+		{&policy{action: blockSinkhole, sinkholeAs: []net.IP{net.ParseIP("192.0.2.1").To4()}}, dns.TypeTXT, dns.RcodeSuccess, dns.RcodeSuccess, 0},
+	}
+
+	for i, tt := range tests {
+		r := new(dns.Msg)
+		r.SetQuestion("phish.eth.link.", tt.qtype)
+		r.SetEdns0(4096, false)
+		state := request.Request{W: &test.ResponseWriter{}, Req: r}
+		a := new(dns.Msg)
+		a.SetReply(r)
+		rcode := tt.policy.respond(state, a, 300)
+		if rcode != tt.rcode || a.Rcode != tt.msgCode || len(a.Answer) != tt.answers {
+			t.Errorf("Test %d: unexpected rcode %d, message rcode %d, answers %v", i, rcode, a.Rcode, a.Answer)
+		}
+		if tt.answers > 0 && (a.Answer[0].Header().Name != "phish.eth.link." || a.Answer[0].Header().Ttl != 300) {
+			t.Errorf("Test %d: unexpected answer %v", i, a.Answer[0])
+		}
+		opt := a.IsEdns0()
+		if opt == nil || len(opt.Option) != 1 || opt.Option[0].(*dns.EDNS0_EDE).InfoCode != dns.ExtendedErrorCodeBlocked {
+			t.Errorf("Test %d: blocked EDE not present", i)
+		}
+	}
+}
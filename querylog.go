@@ -0,0 +1,107 @@
+package ens
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// queryLogKey is the context key for the query log entry of a request.
+type queryLogKey struct{}
+
+// queryLogEntry is the information about a request that is written to the
+// structured query log.
+type queryLogEntry struct {
+	mu        sync.Mutex
+	started   time.Time
+	QName     string   `json:"qname"`
+	QType     string   `json:"qtype"`
+	ENSName   string   `json:"ens_name,omitempty"`
+	Resolvers []string `json:"resolvers,omitempty"`
+	Paths     []string `json:"paths,omitempty"`
+	RPCCalls  int      `json:"rpc_calls"`
+	RPCShared int      `json:"rpc_shared_calls"`
+	RPCTime   float64  `json:"rpc_time_ms"`
+	Duration  float64  `json:"duration_ms"`
+	Result    string   `json:"result"`
+}
+
+// withQueryLogEntry returns a context that carries the given query log entry.
+func withQueryLogEntry(ctx context.Context, entry *queryLogEntry) context.Context {
+	return context.WithValue(ctx, queryLogKey{}, entry)
+}
+
+// queryLogEntryFrom returns the query log entry in the context, or nil if
+// there is none.  All methods of a nil entry are no-ops.
+func queryLogEntryFrom(ctx context.Context) *queryLogEntry {
+	entry, _ := ctx.Value(queryLogKey{}).(*queryLogEntry)
+	return entry
+}
+
+// addResolver records a resolver contract consulted for the request.
+func (q *queryLogEntry) addResolver(address string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, resolver := range q.Resolvers {
+		if resolver == address {
+			return
+		}
+	}
+	q.Resolvers = append(q.Resolvers, address)
+}
+
+// addPath records a path taken to answer the request.
+func (q *queryLogEntry) addPath(path string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Paths = append(q.Paths, path)
+}
+
+// addRPC records a call made to the Ethereum node for the request.
+func (q *queryLogEntry) addRPC(duration time.Duration) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.RPCCalls++
+	q.RPCTime += float64(duration) / float64(time.Millisecond)
+}
+
+// addSharedRPC records a call for the request that was answered by an
+// identical call made for another request, along with the time spent waiting
+// for it.
+func (q *queryLogEntry) addSharedRPC(duration time.Duration) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.RPCShared++
+	q.RPCTime += float64(duration) / float64(time.Millisecond)
+}
+
+// finish completes the entry with the result of the request and writes it
+// to the log.
+func (q *queryLogEntry) finish(result string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Result = result
+	q.Duration = float64(time.Since(q.started)) / float64(time.Millisecond)
+	data, err := json.Marshal(q)
+	if err != nil {
+		log.Warningf("failed to create query log entry: %v", err)
+		return
+	}
+	log.Info(string(data))
+}
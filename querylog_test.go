@@ -0,0 +1,58 @@
+package ens
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	golog "log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryLogEntry(t *testing.T) {
+	// A context without an entry gives a nil entry, which must be safe to use.
+	nilEntry := queryLogEntryFrom(context.Background())
+	if nilEntry != nil {
+		t.Fatalf("Unexpected entry in empty context")
+	}
+	nilEntry.addPath("contenthash")
+	nilEntry.addResolver("0x00")
+	nilEntry.addRPC(time.Millisecond)
+	nilEntry.addSharedRPC(time.Millisecond)
+	nilEntry.finish(Success.String())
+
+	entry := &queryLogEntry{started: time.Now(), QName: "wealdtech.eth.link.", QType: "A", ENSName: "wealdtech.eth."}
+	ctx := withQueryLogEntry(context.Background(), entry)
+	queryLogEntryFrom(ctx).addPath("contenthash")
+	queryLogEntryFrom(ctx).addResolver("0x4976fb03C32e5B8cfe2b6cCB31c09Ba78EBaBa41")
+	queryLogEntryFrom(ctx).addResolver("0x4976fb03C32e5B8cfe2b6cCB31c09Ba78EBaBa41")
+	queryLogEntryFrom(ctx).addRPC(2 * time.Millisecond)
+	queryLogEntryFrom(ctx).addRPC(3 * time.Millisecond)
+	queryLogEntryFrom(ctx).addSharedRPC(4 * time.Millisecond)
+
+	var buf bytes.Buffer
+	golog.SetOutput(&buf)
+	defer golog.SetOutput(os.Stderr)
+	entry.finish(Success.String())
+
+	output := buf.String()
+	start := strings.Index(output, "{")
+	if start == -1 {
+		t.Fatalf("No JSON in log output %q", output)
+	}
+	var logged map[string]interface{}
+	if err := json.Unmarshal([]byte(output[start:]), &logged); err != nil {
+		t.Fatalf("Invalid JSON in log output %q: %v", output, err)
+	}
+	if logged["qname"] != "wealdtech.eth.link." || logged["ens_name"] != "wealdtech.eth." || logged["result"] != "Success" {
+		t.Errorf("Unexpected log entry %v", logged)
+	}
+	if resolvers, ok := logged["resolvers"].([]interface{}); !ok || len(resolvers) != 1 {
+		t.Errorf("Unexpected resolvers %v", logged["resolvers"])
+	}
+	if logged["rpc_calls"] != float64(2) || logged["rpc_shared_calls"] != float64(1) || logged["rpc_time_ms"].(float64) < 9 {
+		t.Errorf("Unexpected RPC information %v/%v/%v", logged["rpc_calls"], logged["rpc_shared_calls"], logged["rpc_time_ms"])
+	}
+}
@@ -1,6 +1,7 @@
 package ens
 
 import (
+	"context"
 	"strings"
 
 	"github.com/coredns/coredns/request"
@@ -23,23 +24,41 @@ const (
 	ServerFailure
 )
 
+// String returns the name of the result.
+func (r Result) String() string {
+	switch r {
+	case Success:
+		return "Success"
+	case NameError:
+		return "NameError"
+	case Delegation:
+		return "Delegation"
+	case NoData:
+		return "NoData"
+	case ServerFailure:
+		return "ServerFailure"
+	default:
+		return "Unknown"
+	}
+}
+
 // Server is an interface defined by any plugin that wishes to serve
 // authoritative records
 type Server interface {
 	// Query returns records for a specific domain, name, and resource type
-	Query(domain string, qname string, qtype uint16, do bool) ([]dns.RR, error)
+	Query(ctx context.Context, domain string, qname string, qtype uint16, do bool) ([]dns.RR, error)
 
 	// HasRecords checks if there are any records for a specific domain and name
 	// This is used to check for wildcard eligibility
-	HasRecords(domain string, qname string) (bool, error)
+	HasRecords(ctx context.Context, domain string, qname string) (bool, error)
 
 	// IsAuthoritative returns true if this server is authoritative for the
 	// supplied domain
-	IsAuthoritative(qdomain string) bool
+	IsAuthoritative(ctx context.Context, qdomain string) bool
 }
 
 // Obtain the lowest domain for which we are authoritative
-func lowestAuthoritativeDomain(server Server, name string) string {
+func lowestAuthoritativeDomain(ctx context.Context, server Server, name string) string {
 	parts := strings.Split(name, ".")
 	var authoritativeDomain string
 	testDomain := ""
@@ -47,7 +66,7 @@ func lowestAuthoritativeDomain(server Server, name string) string {
 	// (domains are dot-terminated).
 	for i := len(parts) - 2; i >= 0; i-- {
 		testDomain = parts[i] + "." + testDomain
-		if server.IsAuthoritative(testDomain) {
+		if server.IsAuthoritative(ctx, testDomain) {
 			authoritativeDomain = testDomain
 		}
 	}
@@ -55,9 +74,9 @@ func lowestAuthoritativeDomain(server Server, name string) string {
 }
 
 // Obtain the highest domain for which we are authoritative
-func highestAuthoritativeDomain(server Server, name string) string {
+func highestAuthoritativeDomain(ctx context.Context, server Server, name string) string {
 	for name != "" {
-		if server.IsAuthoritative(name) {
+		if server.IsAuthoritative(ctx, name) {
 			return name
 		}
 		dotIndex := strings.Index(name, ".")
@@ -67,12 +86,43 @@ func highestAuthoritativeDomain(server Server, name string) string {
 			break
 		}
 	}
-	return "."
+	return ""
 }
 
 // Lookup contains the logic required to move through A DNS hierarchy and
-// gather the appropriate records
-func Lookup(server Server, state request.Request) ([]dns.RR, []dns.RR, []dns.RR, Result) {
+// gather the appropriate records.  CNAME, DNAME and wildcard records are
+// followed until a loop is found or too many names have been visited, at
+// which point the lookup fails.
+func Lookup(ctx context.Context, server Server, state request.Request) ([]dns.RR, []dns.RR, []dns.RR, Result) {
+	span, ctx := startSpan(ctx, "ens.Lookup")
+	defer span.Finish()
+	span.SetTag("name", state.Name())
+	span.SetTag("type", state.Type())
+
+	c := chaseFrom(ctx)
+	if c == nil {
+		c = newChase()
+		ctx = withChase(ctx, c)
+	}
+	name := strings.ToLower(state.Name())
+	if !strings.HasSuffix(name, ".") {
+		name = name + "."
+	}
+	if err := c.visit(name); err != nil {
+		if _, isBlocked := err.(*blockedError); !isBlocked {
+			log.Warningf("failed to look up %s: %v", state.Name(), err)
+		}
+		span.SetTag("error", err.Error())
+		span.SetTag("result", ServerFailure.String())
+		return nil, nil, nil, ServerFailure
+	}
+
+	answerRrs, authorityRrs, additionalRrs, result := lookup(ctx, server, state)
+	span.SetTag("result", result.String())
+	return answerRrs, authorityRrs, additionalRrs, result
+}
+
+func lookup(ctx context.Context, server Server, state request.Request) ([]dns.RR, []dns.RR, []dns.RR, Result) {
 	qtype := state.QType()
 	do := state.Do()
 
@@ -85,7 +135,7 @@ func Lookup(server Server, state request.Request) ([]dns.RR, []dns.RR, []dns.RR,
 	if !strings.HasSuffix(name, ".") {
 		name = name + "."
 	}
-	domain := highestAuthoritativeDomain(server, name)
+	domain := highestAuthoritativeDomain(ctx, server, name)
 	if domain == "" {
 		// We aren't authoritative for anything here
 		return nil, nil, nil, NoData
@@ -98,7 +148,7 @@ func Lookup(server Server, state request.Request) ([]dns.RR, []dns.RR, []dns.RR,
 		if dnameName == domain {
 			break
 		}
-		dnameRrs, err := server.Query(domain, dnameName, dns.TypeDNAME, do)
+		dnameRrs, err := server.Query(ctx, domain, dnameName, dns.TypeDNAME, do)
 		if err != nil {
 			return nil, nil, nil, ServerFailure
 		}
@@ -111,7 +161,7 @@ func Lookup(server Server, state request.Request) ([]dns.RR, []dns.RR, []dns.RR,
 			newReq := state.Req.Copy()
 			newReq.Question[0].Name = synthName
 			newState := request.Request{W: state.W, Req: newReq}
-			dnameAnswerRrs, dnameAuthorityRrs, dnameAdditionalRrs, dnameResult := Lookup(server, newState)
+			dnameAnswerRrs, dnameAuthorityRrs, dnameAdditionalRrs, dnameResult := Lookup(ctx, server, newState)
 			if dnameResult == Success {
 				answerRrs = append(answerRrs, dnameAnswerRrs...)
 				authorityRrs = append(authorityRrs, dnameAuthorityRrs...)
@@ -134,7 +184,7 @@ func Lookup(server Server, state request.Request) ([]dns.RR, []dns.RR, []dns.RR,
 	}
 
 	// Wildcard substitution
-	if eligibleForWildcard(server, domain, name) {
+	if eligibleForWildcard(ctx, server, domain, name) {
 		// We don't have any records for this name so try again using '*' instead of the actual name
 		wildcardName := replaceWithAsteriskLabel(name)
 		if wildcardName != name {
@@ -142,7 +192,7 @@ func Lookup(server Server, state request.Request) ([]dns.RR, []dns.RR, []dns.RR,
 			newReq.Question[0].Name = wildcardName
 			newState := request.Request{W: state.W, Req: newReq}
 
-			wildcardAnswerRrs, wildcardAuthorityRrs, wildcardAdditionalRrs, wildcardResult := Lookup(server, newState)
+			wildcardAnswerRrs, wildcardAuthorityRrs, wildcardAdditionalRrs, wildcardResult := Lookup(ctx, server, newState)
 			if wildcardResult == Success {
 				// Replace the wildcard results with original query results
 				for _, answerRr := range wildcardAnswerRrs {
@@ -169,7 +219,7 @@ func Lookup(server Server, state request.Request) ([]dns.RR, []dns.RR, []dns.RR,
 	}
 
 	if qtype == dns.TypeNS {
-		nsRrs, err := server.Query(domain, domain, dns.TypeNS, do)
+		nsRrs, err := server.Query(ctx, domain, domain, dns.TypeNS, do)
 		if err != nil {
 			return nil, nil, nil, ServerFailure
 		}
@@ -181,11 +231,11 @@ func Lookup(server Server, state request.Request) ([]dns.RR, []dns.RR, []dns.RR,
 		glueRrs := make([]dns.RR, 0)
 		for i := 0; i < len(nsRrs); i++ {
 			nameserver := nsRrs[i].(*dns.NS).Ns
-			glueARrs, err := server.Query(domain, nameserver, dns.TypeA, do)
+			glueARrs, err := server.Query(ctx, domain, nameserver, dns.TypeA, do)
 			if err == nil {
 				glueRrs = append(glueRrs, glueARrs...)
 			}
-			glueAAAARrs, err := server.Query(domain, nameserver, dns.TypeAAAA, do)
+			glueAAAARrs, err := server.Query(ctx, domain, nameserver, dns.TypeAAAA, do)
 			if err == nil {
 				glueRrs = append(glueRrs, glueAAAARrs...)
 			}
@@ -196,7 +246,7 @@ func Lookup(server Server, state request.Request) ([]dns.RR, []dns.RR, []dns.RR,
 	// If we aren't asking for a CNAME then check for one to see if we need
 	// to recurse
 	if qtype != dns.TypeCNAME {
-		cnameRrs, err := server.Query(domain, name, dns.TypeCNAME, do)
+		cnameRrs, err := server.Query(ctx, domain, name, dns.TypeCNAME, do)
 		if err != nil {
 			return nil, nil, nil, ServerFailure
 		}
@@ -210,17 +260,22 @@ func Lookup(server Server, state request.Request) ([]dns.RR, []dns.RR, []dns.RR,
 			newReq.Question[0].Qtype = qtype
 			newState := request.Request{W: state.W, Req: newReq}
 			// Recurse with our new request
-			cnameAnswerRrs, cnameAuthorityRrs, cnameAdditionalrs, cnameResult := Lookup(server, newState)
+			cnameAnswerRrs, cnameAuthorityRrs, cnameAdditionalrs, cnameResult := Lookup(ctx, server, newState)
 			if cnameResult == Success {
 				answerRrs = append(answerRrs, cnameAnswerRrs...)
 				authorityRrs = append(authorityRrs, cnameAuthorityRrs...)
 				additionalRrs = append(additionalRrs, cnameAdditionalrs...)
 			}
+			if cnameResult == NoData {
+				// The target has no data here (possibly because it is out of
+				// our zones) but the CNAME itself is an answer.
+				return answerRrs, authorityRrs, additionalRrs, Success
+			}
 			return answerRrs, authorityRrs, additionalRrs, cnameResult
 		}
 	}
 	// Fetch actual answer record(s)
-	rrs, err := server.Query(domain, name, qtype, do)
+	rrs, err := server.Query(ctx, domain, name, qtype, do)
 	if err != nil {
 		return nil, nil, nil, ServerFailure
 	}
@@ -1,12 +1,15 @@
 package ens
 
 import (
+	"context"
 	"testing"
 
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
 	"github.com/coredns/coredns/plugin/test"
 	"github.com/coredns/coredns/request"
 	"github.com/miekg/dns"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 )
 
 type Record struct {
@@ -40,13 +43,18 @@ var server = MockServer{
 			{"foo.example.com.", dns.ClassINET, dns.TypeDNAME, "foo.example.com. 3600 IN DNAME bar.example.com."},
 			{"bar.example.com.", dns.ClassINET, dns.TypeA, "bar.example.com. 3600 IN A 1.1.2.3"},
 			{"foo.bar.example.com.", dns.ClassINET, dns.TypeA, "foo.bar.example.com. 3600 IN A 1.1.2.4"},
+			{"out.example.com.", dns.ClassINET, dns.TypeCNAME, "out.example.com. 3600 IN CNAME elsewhere.org."},
+			{"loop1.example.com.", dns.ClassINET, dns.TypeCNAME, "loop1.example.com. 3600 IN CNAME loop2.example.com."},
+			{"loop2.example.com.", dns.ClassINET, dns.TypeCNAME, "loop2.example.com. 3600 IN CNAME loop1.example.com."},
+			{"self.example.com.", dns.ClassINET, dns.TypeCNAME, "self.example.com. 3600 IN CNAME self.example.com."},
+			{"deep.example.com.", dns.ClassINET, dns.TypeDNAME, "deep.example.com. 3600 IN DNAME sub.deep.example.com."},
 		}},
 		{name: "example.net.", records: []Record{}},
 		{name: "mine.", records: []Record{}},
 	},
 }
 
-func (m MockServer) IsAuthoritative(qname string) bool {
+func (m MockServer) IsAuthoritative(ctx context.Context, qname string) bool {
 	for _, zone := range m.zones {
 		if zone.name == qname {
 			return true
@@ -55,7 +63,7 @@ func (m MockServer) IsAuthoritative(qname string) bool {
 	return false
 }
 
-func (m MockServer) Query(zone string, domain string, qtype uint16, do bool) ([]dns.RR, error) {
+func (m MockServer) Query(ctx context.Context, zone string, domain string, qtype uint16, do bool) ([]dns.RR, error) {
 	results := make([]dns.RR, 0)
 	for _, serverZone := range m.zones {
 		if serverZone.name == zone {
@@ -89,7 +97,7 @@ func (m MockServer) NumRecords(zone string, domain string) (uint16, error) {
 	return records, nil
 }
 
-func (m MockServer) HasRecords(zone string, domain string) (bool, error) {
+func (m MockServer) HasRecords(ctx context.Context, zone string, domain string) (bool, error) {
 	numRecords, err := m.NumRecords(zone, domain)
 	if err != nil {
 		return false, err
@@ -118,7 +126,7 @@ func TestQuery(t *testing.T) {
 		{"example.net.", "example.net.", dns.TypeNS, false, []dns.RR{}},
 	}
 	for i, tt := range tests {
-		rrs, err := server.Query(tt.zone, tt.domain, tt.resource, tt.do)
+		rrs, err := server.Query(context.Background(), tt.zone, tt.domain, tt.resource, tt.do)
 		if err != nil {
 			t.Errorf("Test %d errored unexpectedly\n", i)
 		}
@@ -199,7 +207,7 @@ func TestLookup(t *testing.T) {
 		a.SetReply(r)
 		a.Compress = true
 		a.Authoritative = true
-		a.Answer, a.Ns, a.Extra, _ = Lookup(server, state)
+		a.Answer, a.Ns, a.Extra, _ = Lookup(context.Background(), server, state)
 
 		state.SizeAndDo(a)
 		rec.WriteMsg(a)
@@ -209,6 +217,20 @@ func TestLookup(t *testing.T) {
 	}
 }
 
+func TestLookupCNAMEOutOfZone(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("out.example.com.", dns.TypeA)
+	state := request.Request{W: &test.ResponseWriter{}, Req: r}
+
+	answer, _, _, result := Lookup(context.Background(), server, state)
+	if result != Success {
+		t.Fatalf("Unexpected result %v", result)
+	}
+	if len(answer) != 1 || answer[0].String() != test.CNAME("out.example.com. 3600 IN CNAME elsewhere.org.").String() {
+		t.Fatalf("Unexpected answer %v", answer)
+	}
+}
+
 func TestAuthoritativeDomain(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -225,9 +247,75 @@ func TestAuthoritativeDomain(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		result := lowestAuthoritativeDomain(server, tt.name)
+		result := lowestAuthoritativeDomain(context.Background(), server, tt.name)
 		if tt.result != result {
 			t.Errorf("Failure: %v => %v (expected %v)\n", tt.name, result, tt.result)
 		}
 	}
 }
+
+func TestLookupTracing(t *testing.T) {
+	tracer := mocktracer.New()
+	parent := tracer.StartSpan("ens")
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+
+	r := new(dns.Msg)
+	r.SetQuestion("www.example.com.", dns.TypeA)
+	state := request.Request{W: &test.ResponseWriter{}, Req: r}
+	Lookup(ctx, server, state)
+	parent.Finish()
+
+	// The CNAME results in a second, nested, lookup.
+	lookups := make([]*mocktracer.MockSpan, 0)
+	for _, span := range tracer.FinishedSpans() {
+		if span.OperationName == "ens.Lookup" {
+			lookups = append(lookups, span)
+		}
+	}
+	if len(lookups) != 2 {
+		t.Fatalf("Expected 2 lookup spans, got %d", len(lookups))
+	}
+	// Spans finish innermost first.
+	if lookups[0].Tag("name") != "example.com." || lookups[0].ParentID != lookups[1].SpanContext.SpanID {
+		t.Errorf("Unexpected inner lookup span %v", lookups[0])
+	}
+	if lookups[1].Tag("name") != "www.example.com." || lookups[1].ParentID != parent.(*mocktracer.MockSpan).SpanContext.SpanID {
+		t.Errorf("Unexpected outer lookup span %v", lookups[1])
+	}
+	if lookups[1].Tag("result") != "Success" {
+		t.Errorf("Unexpected result %v", lookups[1].Tag("result"))
+	}
+}
+
+func TestLookupLoops(t *testing.T) {
+	tests := []struct {
+		name string
+		err  string
+	}{
+		{"loop1.example.com.", "loop detected at loop1.example.com."},
+		{"self.example.com.", "loop detected at self.example.com."},
+		// Each DNAME substitution results in a new, longer, name.
+		{"a.deep.example.com.", "more than 16 names followed at a.sub.sub.sub.sub.sub.sub.sub.sub.sub.sub.sub.sub.sub.sub.sub.sub.deep.example.com."},
+		{"www.example.com.", ""},
+	}
+
+	for _, tt := range tests {
+		r := new(dns.Msg)
+		r.SetQuestion(tt.name, dns.TypeA)
+		state := request.Request{W: &test.ResponseWriter{}, Req: r}
+		c := newChase()
+		_, _, _, result := Lookup(withChase(context.Background(), c), server, state)
+		if tt.err == "" {
+			if result != Success || c.err != nil {
+				t.Errorf("%s: unexpected result %v, error %v", tt.name, result, c.err)
+			}
+			continue
+		}
+		if result != ServerFailure {
+			t.Errorf("%s: unexpected result %v", tt.name, result)
+		}
+		if c.err == nil || c.err.Error() != tt.err {
+			t.Errorf("%s: unexpected error %v", tt.name, c.err)
+		}
+	}
+}
@@ -1,15 +1,25 @@
 package ens
 
 import (
+	"net"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/fall"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/miekg/dns"
 	ens "github.com/wealdtech/go-ens/v3"
 )
 
+var log = clog.NewWithPlugin("ens")
+
 func init() {
 	caddy.RegisterPlugin("ens", caddy.Plugin{
 		ServerType: "dns",
@@ -18,89 +28,666 @@ func init() {
 }
 
 func setupENS(c *caddy.Controller) error {
-	connection, ethLinkNameServers, ipfsGatewayAs, ipfsGatewayAAAAs, err := ensParse(c)
+	cfg, err := ensParse(c)
 	if err != nil {
 		return plugin.Error("ens", err)
 	}
 
-	client, err := ethclient.Dial(connection)
+	client, err := ethclient.Dial(cfg.connection)
 	if err != nil {
 		return plugin.Error("ens", err)
 	}
 
+	backend := &instrumentedBackend{ContractBackend: client, timeout: cfg.rpcTimeout}
+
 	// Obtain the registry contract
-	registry, err := ens.NewRegistry(client)
+	registry, err := ens.NewRegistry(backend)
 	if err != nil {
 		return plugin.Error("ens", err)
 	}
 
+	var blockPolicy *policy
+	if len(cfg.blocklists) > 0 {
+		blockPolicy = newPolicy(cfg, dnsserver.GetConfig(c).Root)
+		if err := blockPolicy.load(); err != nil {
+			return plugin.Error("ens", err)
+		}
+	}
+
+	var selector *gatewaySelector
+	if len(cfg.gatewayPools) > 0 {
+		if cfg.gatewayGeoIP != "" && !filepath.IsAbs(cfg.gatewayGeoIP) && dnsserver.GetConfig(c).Root != "" {
+			cfg.gatewayGeoIP = filepath.Join(dnsserver.GetConfig(c).Root, cfg.gatewayGeoIP)
+		}
+		selector, err = newGatewaySelector(cfg)
+		if err != nil {
+			return plugin.Error("ens", err)
+		}
+	}
+
+	var balancer *gatewayBalancer
+	if cfg.gatewayOrder != gatewayOrderFixed || cfg.gatewayMax > 0 {
+		balancer = newGatewayBalancer(cfg.gatewayOrder, cfg.gatewayWeights, cfg.gatewayMax)
+	}
+
+	var challenges *challengeStore
+	if cfg.acmeChallenges != "" || cfg.acmeListen != "" {
+		if cfg.acmeChallenges != "" && !filepath.IsAbs(cfg.acmeChallenges) && dnsserver.GetConfig(c).Root != "" {
+			cfg.acmeChallenges = filepath.Join(dnsserver.GetConfig(c).Root, cfg.acmeChallenges)
+		}
+		var api *challengeAPI
+		if cfg.acmeListen != "" {
+			api = challengeAPIFor(cfg.acmeListen)
+		}
+		challenges = newChallengeStore(cfg.acmeChallenges, cfg.zones, cfg.suffixMappings, api)
+	}
+
+	var gateways *gatewayChecker
+	if cfg.gatewayCheckPort != 0 {
+		gateways = newGatewayChecker(cfg)
+	}
+
+	monitor := newHealthMonitor(client, backend, registry.ContractAddr, cfg.maxBlockLag)
+
+	stop := make(chan struct{})
+	c.OnStartup(func() error {
+		registerMetrics(c)
+		go monitor.run(stop)
+		if blockPolicy != nil {
+			go blockPolicy.run(stop)
+		}
+		if gateways != nil {
+			go gateways.run(stop)
+		}
+		if challenges != nil && challenges.api != nil {
+			// The API is shared with other server blocks and with the
+			// instance being replaced on a reload.
+			return challenges.api.register(challenges)
+		}
+		return nil
+	})
+	c.OnShutdown(func() error {
+		close(stop)
+		if err := selector.close(); err != nil {
+			return err
+		}
+		if challenges != nil && challenges.api != nil {
+			return challenges.api.unregister(challenges)
+		}
+		return nil
+	})
+
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		return ENS{
 			Next:               next,
 			Client:             client,
-			EthLinkNameServers: ethLinkNameServers,
+			Backend:            backend,
+			EthLinkNameServers: cfg.ethLinkNameServers,
 			Registry:           registry,
-			IPFSGatewayAs:      ipfsGatewayAs,
-			IPFSGatewayAAAAs:   ipfsGatewayAAAAs,
+			GatewayAs:          cfg.gatewayAs,
+			GatewayAAAAs:       cfg.gatewayAAAAs,
+			Gateways:           gateways,
+			GatewaySelector:    selector,
+			GatewayBalancer:    balancer,
+			TTLs:               cfg.ttls,
+			TTLRecord:          cfg.ttlRecord,
+			CAAs:               cfg.caas,
+			Challenges:         challenges,
+			SubdomainGateway:   cfg.subdomainGateway,
+			HTTPSALPN:          cfg.httpsALPN,
+			HTTPSPort:          cfg.httpsPort,
+			HTTPSHints:         cfg.httpsHints,
+			TextRecords:        cfg.textRecords,
+			Coins:              cfg.coins,
+			SuffixMappings:     cfg.suffixMappings,
+			Zones:              cfg.zones,
+			Fall:               cfg.fall,
+			QueryLog:           cfg.queryLog,
+			Monitor:            monitor,
+			LookupTimeout:      cfg.lookupTimeout,
+			Policy:             blockPolicy,
+			Expiry:             newExpiryChecker(cfg.expiryGrace, cfg.nameWrapper),
 		}
 	})
 
 	return nil
 }
 
-func ensParse(c *caddy.Controller) (string, []string, []string, []string, error) {
-	var connection string
-	ethLinkNameServers := make([]string, 0)
-	ipfsGatewayAs := make([]string, 0)
-	ipfsGatewayAAAAs := make([]string, 0)
+// config is the configuration of the ENS plugin as supplied in the Corefile.
+type config struct {
+	connection         string
+	ethLinkNameServers []string
+	ipfsGatewayAs      []string
+	ipfsGatewayAAAAs   []string
+	gatewayAs          map[string][]string
+	gatewayAAAAs       map[string][]string
+	subdomainGateway   string
+	httpsALPN          []string
+	httpsPort          uint16
+	httpsHints         bool
+	textRecords        []string
+	coins              []uint64
+	suffixMappings     []suffixMapping
+	zones              []string
+	fall               fall.F
+	queryLog           bool
+	maxBlockLag        time.Duration
+	lookupTimeout      time.Duration
+	rpcTimeout         time.Duration
+	blocklists         []string
+	blockAction        blockAction
+	sinkholeAs         []net.IP
+	sinkholeAAAAs      []net.IP
+	blocklistReload    time.Duration
+	expiryGrace        time.Duration
+	nameWrapper        common.Address
+
+	// gatewayCheckPort enables health checks of the gateway addresses.
+	gatewayCheckPort          int
+	gatewayCheckCID           string
+	gatewayCheckInterval      time.Duration
+	gatewayUnhealthyThreshold int
+	gatewayHealthyThreshold   int
+
+	// gatewayPools are the pools of IPFS gateway addresses by name.
+	gatewayPools    map[string][]string
+	gatewaySubnets  []subnetPool
+	gatewayGeoIP    string
+	gatewayGeoPools map[string]string
+
+	// gatewayOrder is the order in which gateway addresses are returned.
+	gatewayOrder   gatewayOrder
+	gatewayWeights map[string]int
+	gatewayMax     int
+
+	// ttls are the TTLs of synthesized records by type.
+	ttls      map[uint16]uint32
+	ttlRecord string
+
+	// caas are the CAA records synthesized for domains with a contenthash.
+	caas           []dns.CAA
+	acmeChallenges string
+	acmeListen     string
+}
+
+func ensParse(c *caddy.Controller) (*config, error) {
+	cfg := &config{
+		ethLinkNameServers: make([]string, 0),
+		ipfsGatewayAs:      make([]string, 0),
+		ipfsGatewayAAAAs:   make([]string, 0),
+		gatewayAs:          make(map[string][]string),
+		gatewayAAAAs:       make(map[string][]string),
+		textRecords:        make([]string, 0),
+		coins:              make([]uint64, 0),
+		suffixMappings:     make([]suffixMapping, 0),
+		maxBlockLag:        defaultMaxBlockLag,
+		lookupTimeout:      defaultLookupTimeout,
+		rpcTimeout:         defaultRPCTimeout,
+		blocklistReload:    defaultBlocklistReload,
+		nameWrapper:        defaultNameWrapper,
+
+		gatewayCheckInterval:      defaultGatewayCheckInterval,
+		gatewayUnhealthyThreshold: defaultGatewayUnhealthyThreshold,
+		gatewayHealthyThreshold:   defaultGatewayHealthyThreshold,
+
+		gatewayPools:    make(map[string][]string),
+		gatewayGeoPools: make(map[string]string),
+
+		gatewayWeights: make(map[string]int),
+
+		ttls: make(map[uint16]uint32),
+	}
 
 	c.Next()
+	// The plugin serves the zones of its server block, unless others are supplied.
+	cfg.zones = make([]string, len(c.ServerBlockKeys))
+	copy(cfg.zones, c.ServerBlockKeys)
+	if args := c.RemainingArgs(); len(args) > 0 {
+		cfg.zones = args
+	}
+	for i := range cfg.zones {
+		cfg.zones[i] = plugin.Host(cfg.zones[i]).Normalize()
+	}
+
 	for c.NextBlock() {
 		switch strings.ToLower(c.Val()) {
 		case "connection":
 			args := c.RemainingArgs()
 			if len(args) == 0 {
-				return "", nil, nil, nil, c.Errf("invalid connection; no value")
+				return nil, c.Errf("invalid connection; no value")
 			}
 			if len(args) > 1 {
-				return "", nil, nil, nil, c.Errf("invalid connection; multiple values")
+				return nil, c.Errf("invalid connection; multiple values")
 			}
-			connection = args[0]
+			cfg.connection = args[0]
 		case "ethlinknameservers":
 			args := c.RemainingArgs()
 			if len(args) == 0 {
-				return "", nil, nil, nil, c.Errf("invalid ethlinknameservers; no value")
+				return nil, c.Errf("invalid ethlinknameservers; no value")
 			}
-			ethLinkNameServers = make([]string, len(args))
-			copy(ethLinkNameServers, args)
+			cfg.ethLinkNameServers = make([]string, len(args))
+			copy(cfg.ethLinkNameServers, args)
 		case "ipfsgatewaya":
 			args := c.RemainingArgs()
 			if len(args) == 0 {
-				return "", nil, nil, nil, c.Errf("invalid IPFS gateway A; no value")
+				return nil, c.Errf("invalid IPFS gateway A; no value")
 			}
-			ipfsGatewayAs = make([]string, len(args))
-			copy(ipfsGatewayAs, args)
+			cfg.ipfsGatewayAs = make([]string, len(args))
+			copy(cfg.ipfsGatewayAs, args)
 		case "ipfsgatewayaaaa":
 			args := c.RemainingArgs()
 			if len(args) == 0 {
-				return "", nil, nil, nil, c.Errf("invalid IPFS gateway AAAA; no value")
+				return nil, c.Errf("invalid IPFS gateway AAAA; no value")
+			}
+			cfg.ipfsGatewayAAAAs = make([]string, len(args))
+			copy(cfg.ipfsGatewayAAAAs, args)
+		case "gatewaya", "gatewayaaaa":
+			option := strings.ToLower(c.Val())
+			args := c.RemainingArgs()
+			if len(args) < 2 {
+				return nil, c.Errf("invalid %s; no value", option)
+			}
+			codec, exists := contenthashCodecName(args[0])
+			if !exists {
+				return nil, c.Errf("invalid %s; unknown codec %s", option, args[0])
+			}
+			for _, arg := range args[1:] {
+				ip := net.ParseIP(arg)
+				if ip == nil || (option == "gatewaya") != (ip.To4() != nil) {
+					return nil, c.Errf("invalid %s; bad address %s", option, arg)
+				}
+			}
+			addresses := make([]string, len(args)-1)
+			copy(addresses, args[1:])
+			if option == "gatewaya" {
+				cfg.gatewayAs[codec] = addresses
+			} else {
+				cfg.gatewayAAAAs[codec] = addresses
+			}
+		case "gatewayorder":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.Errf("invalid gatewayorder; requires a single value")
+			}
+			switch strings.ToLower(args[0]) {
+			case "fixed":
+				cfg.gatewayOrder = gatewayOrderFixed
+			case "shuffle":
+				cfg.gatewayOrder = gatewayOrderShuffle
+			case "weighted":
+				cfg.gatewayOrder = gatewayOrderWeighted
+			default:
+				return nil, c.Errf("invalid gatewayorder; unknown order %s", args[0])
+			}
+		case "gatewayweight":
+			args := c.RemainingArgs()
+			if len(args) < 2 {
+				return nil, c.Errf("invalid gatewayweight; requires a weight and addresses")
+			}
+			weight, err := strconv.Atoi(args[0])
+			if err != nil || weight <= 0 {
+				return nil, c.Errf("invalid gatewayweight; bad weight %s", args[0])
+			}
+			for _, arg := range args[1:] {
+				if net.ParseIP(arg) == nil {
+					return nil, c.Errf("invalid gatewayweight; bad address %s", arg)
+				}
+				cfg.gatewayWeights[arg] = weight
+			}
+		case "gatewaymax":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.Errf("invalid gatewaymax; requires a single value")
+			}
+			max, err := strconv.Atoi(args[0])
+			if err != nil || max <= 0 {
+				return nil, c.Errf("invalid gatewaymax; bad value %s", args[0])
+			}
+			cfg.gatewayMax = max
+		case "gatewaycheck":
+			args := c.RemainingArgs()
+			if len(args) == 0 || len(args) > 2 {
+				return nil, c.Errf("invalid gatewaycheck; requires a port and optional CID")
+			}
+			port, err := strconv.ParseUint(args[0], 10, 16)
+			if err != nil || port == 0 {
+				return nil, c.Errf("invalid gatewaycheck; bad port %s", args[0])
+			}
+			cfg.gatewayCheckPort = int(port)
+			if len(args) == 2 {
+				cfg.gatewayCheckCID = args[1]
+			}
+		case "gatewaycheckinterval":
+			gatewayCheckInterval, err := durationArg(c, "gatewaycheckinterval")
+			if err != nil {
+				return nil, err
+			}
+			cfg.gatewayCheckInterval = gatewayCheckInterval
+		case "gatewaycheckthresholds":
+			args := c.RemainingArgs()
+			if len(args) != 2 {
+				return nil, c.Errf("invalid gatewaycheckthresholds; requires unhealthy and healthy thresholds")
+			}
+			thresholds := make([]int, len(args))
+			for i := range args {
+				threshold, err := strconv.Atoi(args[i])
+				if err != nil || threshold <= 0 {
+					return nil, c.Errf("invalid gatewaycheckthresholds; bad threshold %s", args[i])
+				}
+				thresholds[i] = threshold
+			}
+			cfg.gatewayUnhealthyThreshold = thresholds[0]
+			cfg.gatewayHealthyThreshold = thresholds[1]
+		case "gatewaypool":
+			args := c.RemainingArgs()
+			if len(args) < 2 {
+				return nil, c.Errf("invalid gatewaypool; requires a name and addresses")
+			}
+			for _, arg := range args[1:] {
+				if net.ParseIP(arg) == nil {
+					return nil, c.Errf("invalid gatewaypool; bad address %s", arg)
+				}
+			}
+			addresses := make([]string, len(args)-1)
+			copy(addresses, args[1:])
+			cfg.gatewayPools[args[0]] = addresses
+		case "gatewaysubnet":
+			args := c.RemainingArgs()
+			if len(args) < 2 {
+				return nil, c.Errf("invalid gatewaysubnet; requires a pool and subnets")
+			}
+			for _, arg := range args[1:] {
+				_, subnet, err := net.ParseCIDR(arg)
+				if err != nil {
+					return nil, c.Errf("invalid gatewaysubnet; bad subnet %s", arg)
+				}
+				cfg.gatewaySubnets = append(cfg.gatewaySubnets, subnetPool{subnet: subnet, pool: args[0]})
+			}
+		case "gatewaygeoip":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.Errf("invalid gatewaygeoip; requires a single value")
+			}
+			cfg.gatewayGeoIP = args[0]
+		case "gatewaygeo":
+			args := c.RemainingArgs()
+			if len(args) < 2 {
+				return nil, c.Errf("invalid gatewaygeo; requires a pool and locations")
+			}
+			for _, arg := range args[1:] {
+				cfg.gatewayGeoPools[strings.ToUpper(arg)] = args[0]
+			}
+		case "subdomaingateway":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.Errf("invalid subdomaingateway; requires a single value")
+			}
+			gateway := dns.Fqdn(strings.ToLower(args[0]))
+			if _, isDomain := dns.IsDomainName(gateway); !isDomain || gateway == "." {
+				return nil, c.Errf("invalid subdomaingateway; bad domain %s", args[0])
+			}
+			cfg.subdomainGateway = gateway
+		case "httpsalpn":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return nil, c.Errf("invalid httpsalpn; no value")
+			}
+			cfg.httpsALPN = make([]string, len(args))
+			copy(cfg.httpsALPN, args)
+		case "httpsport":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.Errf("invalid httpsport; requires a single value")
+			}
+			port, err := strconv.ParseUint(args[0], 10, 16)
+			if err != nil || port == 0 {
+				return nil, c.Errf("invalid httpsport; bad port %s", args[0])
+			}
+			cfg.httpsPort = uint16(port)
+		case "httpshints":
+			if len(c.RemainingArgs()) != 0 {
+				return nil, c.Errf("invalid httpshints; takes no value")
+			}
+			cfg.httpsHints = true
+		case "textrecords":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return nil, c.Errf("invalid textrecords; no value")
+			}
+			cfg.textRecords = make([]string, len(args))
+			copy(cfg.textRecords, args)
+		case "coins":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return nil, c.Errf("invalid coins; no value")
+			}
+			cfg.coins = make([]uint64, len(args))
+			for i := range args {
+				id, err := coinType(args[i])
+				if err != nil {
+					return nil, c.Errf("invalid coins; %v", err)
+				}
+				cfg.coins[i] = id
 			}
-			ipfsGatewayAAAAs = make([]string, len(args))
-			copy(ipfsGatewayAAAAs, args)
+		case "suffix":
+			args := c.RemainingArgs()
+			if len(args) != 2 {
+				return nil, c.Errf("invalid suffix; requires DNS suffix and ENS suffix")
+			}
+			cfg.suffixMappings = append(cfg.suffixMappings, suffixMapping{
+				dns: normalizeSuffix(args[0]),
+				ens: normalizeSuffix(args[1]),
+			})
+		case "querylog":
+			if len(c.RemainingArgs()) != 0 {
+				return nil, c.Errf("invalid querylog; takes no value")
+			}
+			cfg.queryLog = true
+		case "maxblocklag":
+			maxBlockLag, err := durationArg(c, "maxblocklag")
+			if err != nil {
+				return nil, err
+			}
+			cfg.maxBlockLag = maxBlockLag
+		case "lookuptimeout":
+			lookupTimeout, err := durationArg(c, "lookuptimeout")
+			if err != nil {
+				return nil, err
+			}
+			cfg.lookupTimeout = lookupTimeout
+		case "rpctimeout":
+			rpcTimeout, err := durationArg(c, "rpctimeout")
+			if err != nil {
+				return nil, err
+			}
+			cfg.rpcTimeout = rpcTimeout
+		case "blocklist":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return nil, c.Errf("invalid blocklist; no value")
+			}
+			cfg.blocklists = append(cfg.blocklists, args...)
+		case "blockaction":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return nil, c.Errf("invalid blockaction; no value")
+			}
+			switch strings.ToLower(args[0]) {
+			case "nxdomain", "refuse":
+				if len(args) != 1 {
+					return nil, c.Errf("invalid blockaction; %s takes no value", args[0])
+				}
+				cfg.blockAction = blockNXDomain
+				if strings.ToLower(args[0]) == "refuse" {
+					cfg.blockAction = blockRefuse
+				}
+			case "sinkhole":
+				if len(args) == 1 {
+					return nil, c.Errf("invalid blockaction; sinkhole requires addresses")
+				}
+				cfg.blockAction = blockSinkhole
+				for _, arg := range args[1:] {
+					ip := net.ParseIP(arg)
+					switch {
+					case ip == nil:
+						return nil, c.Errf("invalid blockaction; bad address %s", arg)
+					case ip.To4() != nil:
+						cfg.sinkholeAs = append(cfg.sinkholeAs, ip.To4())
+					default:
+						cfg.sinkholeAAAAs = append(cfg.sinkholeAAAAs, ip)
+					}
+				}
+			default:
+				return nil, c.Errf("invalid blockaction; unknown action %s", args[0])
+			}
+		case "blockreload":
+			blocklistReload, err := durationArg(c, "blockreload")
+			if err != nil {
+				return nil, err
+			}
+			cfg.blocklistReload = blocklistReload
+		case "expirygrace":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.Errf("invalid expirygrace; requires a single value")
+			}
+			expiryGrace, err := time.ParseDuration(args[0])
+			if err != nil || expiryGrace < 0 {
+				return nil, c.Errf("invalid expirygrace; bad duration %s", args[0])
+			}
+			cfg.expiryGrace = expiryGrace
+		case "namewrapper":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.Errf("invalid namewrapper; requires a single value")
+			}
+			if !common.IsHexAddress(args[0]) {
+				return nil, c.Errf("invalid namewrapper; bad address %s", args[0])
+			}
+			cfg.nameWrapper = common.HexToAddress(args[0])
+		case "ttl":
+			args := c.RemainingArgs()
+			if len(args) != 2 {
+				return nil, c.Errf("invalid ttl; requires a record type and a value")
+			}
+			rrtype, exists := dns.StringToType[strings.ToUpper(args[0])]
+			if _, synthesized := defaultTTLs[rrtype]; !exists || !synthesized {
+				return nil, c.Errf("invalid ttl; unsupported record type %s", args[0])
+			}
+			ttl, err := parseTTL(args[1])
+			if err != nil {
+				return nil, c.Errf("invalid ttl; bad value %s", args[1])
+			}
+			cfg.ttls[rrtype] = ttl
+		case "ttlrecord":
+			args := c.RemainingArgs()
+			switch len(args) {
+			case 0:
+				cfg.ttlRecord = defaultTTLRecord
+			case 1:
+				cfg.ttlRecord = args[0]
+			default:
+				return nil, c.Errf("invalid ttlrecord; multiple values")
+			}
+		case "caa":
+			args := c.RemainingArgs()
+			if len(args) != 3 {
+				return nil, c.Errf("invalid caa; requires a flag, tag and value")
+			}
+			flag, err := strconv.ParseUint(args[0], 10, 8)
+			if err != nil {
+				return nil, c.Errf("invalid caa; bad flag %s", args[0])
+			}
+			if !isCAATag(args[1]) {
+				return nil, c.Errf("invalid caa; bad tag %s", args[1])
+			}
+			cfg.caas = append(cfg.caas, dns.CAA{Flag: uint8(flag), Tag: strings.ToLower(args[1]), Value: args[2]})
+		case "acmechallenges":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.Errf("invalid acmechallenges; requires a single value")
+			}
+			cfg.acmeChallenges = args[0]
+		case "acmelisten":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return nil, c.Errf("invalid acmelisten; requires a single value")
+			}
+			host, _, err := net.SplitHostPort(args[0])
+			if err != nil {
+				return nil, c.Errf("invalid acmelisten; bad address %s", args[0])
+			}
+			if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+				return nil, c.Errf("invalid acmelisten; address %s is not local", args[0])
+			}
+			cfg.acmeListen = args[0]
+		case "fallthrough":
+			cfg.fall.SetZonesFromArgs(c.RemainingArgs())
 		default:
-			return "", nil, nil, nil, c.Errf("unknown value %v", c.Val())
+			return nil, c.Errf("unknown value %v", c.Val())
 		}
 	}
-	if connection == "" {
-		return "", nil, nil, nil, c.Errf("no connection")
+	if cfg.connection == "" {
+		return nil, c.Errf("no connection")
 	}
-	if len(ethLinkNameServers) == 0 {
-		return "", nil, nil, nil, c.Errf("no ethlinknameservers")
+	if len(cfg.ethLinkNameServers) == 0 {
+		return nil, c.Errf("no ethlinknameservers")
+	}
+	for i := range cfg.ethLinkNameServers {
+		if !strings.HasSuffix(cfg.ethLinkNameServers[i], ".") {
+			cfg.ethLinkNameServers[i] = cfg.ethLinkNameServers[i] + "."
+		}
+	}
+	for _, subnet := range cfg.gatewaySubnets {
+		if _, exists := cfg.gatewayPools[subnet.pool]; !exists {
+			return nil, c.Errf("invalid gatewaysubnet; unknown pool %s", subnet.pool)
+		}
+	}
+	for _, pool := range cfg.gatewayGeoPools {
+		if _, exists := cfg.gatewayPools[pool]; !exists {
+			return nil, c.Errf("invalid gatewaygeo; unknown pool %s", pool)
+		}
+	}
+	if len(cfg.gatewayWeights) > 0 && cfg.gatewayOrder != gatewayOrderWeighted {
+		return nil, c.Errf("invalid gatewayweight; requires gatewayorder weighted")
+	}
+	if len(cfg.gatewayGeoPools) > 0 && cfg.gatewayGeoIP == "" {
+		return nil, c.Errf("invalid gatewaygeo; no gatewaygeoip")
+	}
+	// IPFS gateways serve both IPFS and IPNS content unless told otherwise.
+	for _, codec := range []string{"ipfs-ns", "ipns-ns"} {
+		if _, exists := cfg.gatewayAs[codec]; !exists && len(cfg.ipfsGatewayAs) > 0 {
+			cfg.gatewayAs[codec] = cfg.ipfsGatewayAs
+		}
+		if _, exists := cfg.gatewayAAAAs[codec]; !exists && len(cfg.ipfsGatewayAAAAs) > 0 {
+			cfg.gatewayAAAAs[codec] = cfg.ipfsGatewayAAAAs
+		}
+	}
+	return cfg, nil
+}
+
+// durationArg obtains the single positive duration supplied to an option.
+func durationArg(c *caddy.Controller, option string) (time.Duration, error) {
+	args := c.RemainingArgs()
+	if len(args) != 1 {
+		return 0, c.Errf("invalid %s; requires a single value", option)
+	}
+	duration, err := time.ParseDuration(args[0])
+	if err != nil || duration <= 0 {
+		return 0, c.Errf("invalid %s; bad duration %s", option, args[0])
+	}
+	return duration, nil
+}
+
+// isCAATag returns true if the input is a valid CAA property tag.
+func isCAATag(input string) bool {
+	if input == "" {
+		return false
 	}
-	for i := range ethLinkNameServers {
-		if !strings.HasSuffix(ethLinkNameServers[i], ".") {
-			ethLinkNameServers[i] = ethLinkNameServers[i] + "."
+	for _, c := range input {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			return false
 		}
 	}
-	return connection, ethLinkNameServers, ipfsGatewayAs, ipfsGatewayAAAAs, nil
+	return true
 }
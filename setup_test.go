@@ -1,9 +1,12 @@
 package ens
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/coredns/caddy"
+	"github.com/miekg/dns"
 )
 
 func TestENSParse(t *testing.T) {
@@ -180,7 +183,7 @@ func TestENSParse(t *testing.T) {
 	for i, test := range tests {
 		c := caddy.NewTestController("ens", test.inputFileRules)
 		c.Key = test.key
-		connection, ethlinknameservers, ipfsgatewayas, ipfsgatewayaaaas, err := ensParse(c)
+		cfg, err := ensParse(c)
 
 		if test.err != "" {
 			if err == nil {
@@ -193,36 +196,36 @@ func TestENSParse(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Unexpected error \"%s\" at test %d", err.Error(), i)
 			} else {
-				if test.connection != "" && connection != test.connection {
-					t.Fatalf("Test %d connection expected %v, got %v", i, test.connection, connection)
+				if test.connection != "" && cfg.connection != test.connection {
+					t.Fatalf("Test %d connection expected %v, got %v", i, test.connection, cfg.connection)
 				}
 				if test.ethlinknameservers != nil {
-					if len(ethlinknameservers) != len(test.ethlinknameservers) {
-						t.Fatalf("Test %d ethlinknameservers expected %v entries, got %v", i, len(test.ethlinknameservers), len(ethlinknameservers))
+					if len(cfg.ethLinkNameServers) != len(test.ethlinknameservers) {
+						t.Fatalf("Test %d ethlinknameservers expected %v entries, got %v", i, len(test.ethlinknameservers), len(cfg.ethLinkNameServers))
 					}
 					for j := range test.ethlinknameservers {
-						if ethlinknameservers[j] != test.ethlinknameservers[j] {
-							t.Fatalf("Test %d ethlinknameservers expected %v, got %v", i, test.ethlinknameservers[j], ethlinknameservers[j])
+						if cfg.ethLinkNameServers[j] != test.ethlinknameservers[j] {
+							t.Fatalf("Test %d ethlinknameservers expected %v, got %v", i, test.ethlinknameservers[j], cfg.ethLinkNameServers[j])
 						}
 					}
 				}
 				if test.ipfsgatewayas != nil {
-					if len(ipfsgatewayas) != len(test.ipfsgatewayas) {
-						t.Fatalf("Test %d ipfsgatewayas expected %v entries, got %v", i, len(test.ipfsgatewayas), len(ipfsgatewayas))
+					if len(cfg.ipfsGatewayAs) != len(test.ipfsgatewayas) {
+						t.Fatalf("Test %d ipfsgatewayas expected %v entries, got %v", i, len(test.ipfsgatewayas), len(cfg.ipfsGatewayAs))
 					}
 					for j := range test.ipfsgatewayas {
-						if ipfsgatewayas[j] != test.ipfsgatewayas[j] {
-							t.Fatalf("Test %d ipfsgatewayas expected %v, got %v", i, test.ipfsgatewayas[j], ipfsgatewayas[j])
+						if cfg.ipfsGatewayAs[j] != test.ipfsgatewayas[j] {
+							t.Fatalf("Test %d ipfsgatewayas expected %v, got %v", i, test.ipfsgatewayas[j], cfg.ipfsGatewayAs[j])
 						}
 					}
 				}
 				if test.ipfsgatewayaaaas != nil {
-					if len(ipfsgatewayaaaas) != len(test.ipfsgatewayaaaas) {
-						t.Fatalf("Test %d ipfsgatewayaaaas expected %v entries, got %v", i, len(test.ipfsgatewayaaaas), len(ipfsgatewayaaaas))
+					if len(cfg.ipfsGatewayAAAAs) != len(test.ipfsgatewayaaaas) {
+						t.Fatalf("Test %d ipfsgatewayaaaas expected %v entries, got %v", i, len(test.ipfsgatewayaaaas), len(cfg.ipfsGatewayAAAAs))
 					}
 					for j := range test.ipfsgatewayaaaas {
-						if ipfsgatewayaaaas[j] != test.ipfsgatewayaaaas[j] {
-							t.Fatalf("Test %d ipfsgatewayaaaas expected %v, got %v", i, test.ipfsgatewayaaaas[j], ipfsgatewayaaaas[j])
+						if cfg.ipfsGatewayAAAAs[j] != test.ipfsgatewayaaaas[j] {
+							t.Fatalf("Test %d ipfsgatewayaaaas expected %v, got %v", i, test.ipfsgatewayaaaas[j], cfg.ipfsGatewayAAAAs[j])
 						}
 					}
 				}
@@ -230,3 +233,948 @@ func TestENSParse(t *testing.T) {
 		}
 	}
 }
+
+func TestENSParseTextRecords(t *testing.T) {
+	tests := []struct {
+		inputFileRules string
+		err            string
+		textrecords    []string
+	}{
+		{ // 0
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			}`,
+			"",
+			[]string{},
+		},
+		{ // 1
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  textrecords
+			}`,
+			"Testfile:4 - Error during parsing: invalid textrecords; no value",
+			nil,
+		},
+		{ // 2
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  textrecords url email com.twitter
+			}`,
+			"",
+			[]string{"url", "email", "com.twitter"},
+		},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("ens", test.inputFileRules)
+		cfg, err := ensParse(c)
+		if test.err != "" {
+			if err == nil {
+				t.Fatalf("Failed to obtain expected error at test %d", i)
+			}
+			if err.Error() != test.err {
+				t.Fatalf("Unexpected error \"%s\" at test %d", err.Error(), i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error \"%s\" at test %d", err.Error(), i)
+		}
+		if len(cfg.textRecords) != len(test.textrecords) {
+			t.Fatalf("Test %d textrecords expected %v entries, got %v", i, len(test.textrecords), len(cfg.textRecords))
+		}
+		for j := range test.textrecords {
+			if cfg.textRecords[j] != test.textrecords[j] {
+				t.Fatalf("Test %d textrecords expected %v, got %v", i, test.textrecords[j], cfg.textRecords[j])
+			}
+		}
+	}
+}
+
+func TestENSParseCoins(t *testing.T) {
+	tests := []struct {
+		inputFileRules string
+		err            string
+		coins          []uint64
+	}{
+		{ // 0
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  coins
+			}`,
+			"Testfile:4 - Error during parsing: invalid coins; no value",
+			nil,
+		},
+		{ // 1
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  coins btc bad
+			}`,
+			"Testfile:4 - Error during parsing: invalid coins; unknown coin bad",
+			nil,
+		},
+		{ // 2
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  coins btc sol 2147483658
+			}`,
+			"",
+			[]uint64{0, 501, 2147483658},
+		},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("ens", test.inputFileRules)
+		cfg, err := ensParse(c)
+		if test.err != "" {
+			if err == nil {
+				t.Fatalf("Failed to obtain expected error at test %d", i)
+			}
+			if err.Error() != test.err {
+				t.Fatalf("Unexpected error \"%s\" at test %d", err.Error(), i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error \"%s\" at test %d", err.Error(), i)
+		}
+		if len(cfg.coins) != len(test.coins) {
+			t.Fatalf("Test %d coins expected %v entries, got %v", i, len(test.coins), len(cfg.coins))
+		}
+		for j := range test.coins {
+			if cfg.coins[j] != test.coins[j] {
+				t.Fatalf("Test %d coins expected %v, got %v", i, test.coins[j], cfg.coins[j])
+			}
+		}
+	}
+}
+
+func TestENSParseGateways(t *testing.T) {
+	tests := []struct {
+		inputFileRules string
+		err            string
+		gatewayas      map[string][]string
+		gatewayaaaas   map[string][]string
+	}{
+		{ // 0
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  ipfsgatewaya 193.62.81.1
+			  ipfsgatewayaaaa fe80::b8fb:325d:fb5a:40e7
+			}`,
+			"",
+			map[string][]string{"ipfs-ns": {"193.62.81.1"}, "ipns-ns": {"193.62.81.1"}},
+			map[string][]string{"ipfs-ns": {"fe80::b8fb:325d:fb5a:40e7"}, "ipns-ns": {"fe80::b8fb:325d:fb5a:40e7"}},
+		},
+		{ // 1
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  ipfsgatewaya 193.62.81.1
+			  gatewaya ipns 193.62.81.2
+			  gatewaya swarm-ns 193.62.81.3 193.62.81.4
+			  gatewayaaaa arweave fe80::b8fb:325d:fb5a:40e8
+			}`,
+			"",
+			map[string][]string{"ipfs-ns": {"193.62.81.1"}, "ipns-ns": {"193.62.81.2"}, "swarm-ns": {"193.62.81.3", "193.62.81.4"}},
+			map[string][]string{"arweave-ns": {"fe80::b8fb:325d:fb5a:40e8"}},
+		},
+		{ // 2
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  gatewaya swarm
+			}`,
+			"Testfile:4 - Error during parsing: invalid gatewaya; no value",
+			nil,
+			nil,
+		},
+		{ // 3
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  gatewaya bad 193.62.81.1
+			}`,
+			"Testfile:4 - Error during parsing: invalid gatewaya; unknown codec bad",
+			nil,
+			nil,
+		},
+		{ // 4
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  gatewayaaaa onion3 193.62.81.1
+			}`,
+			"Testfile:4 - Error during parsing: invalid gatewayaaaa; bad address 193.62.81.1",
+			nil,
+			nil,
+		},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("ens", test.inputFileRules)
+		cfg, err := ensParse(c)
+		if test.err != "" {
+			if err == nil {
+				t.Fatalf("Failed to obtain expected error at test %d", i)
+			}
+			if err.Error() != test.err {
+				t.Fatalf("Unexpected error \"%s\" at test %d", err.Error(), i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error \"%s\" at test %d", err.Error(), i)
+		}
+		for name, expected := range map[string][2]map[string][]string{
+			"gatewayas":    {test.gatewayas, cfg.gatewayAs},
+			"gatewayaaaas": {test.gatewayaaaas, cfg.gatewayAAAAs},
+		} {
+			if len(expected[0]) != len(expected[1]) {
+				t.Fatalf("Test %d %s expected %v codecs, got %v", i, name, len(expected[0]), len(expected[1]))
+			}
+			for codec, addresses := range expected[0] {
+				if strings.Join(expected[1][codec], " ") != strings.Join(addresses, " ") {
+					t.Fatalf("Test %d %s %s expected %v, got %v", i, name, codec, addresses, expected[1][codec])
+				}
+			}
+		}
+	}
+}
+
+func TestENSParseSuffixes(t *testing.T) {
+	tests := []struct {
+		inputFileRules string
+		err            string
+		suffixes       []suffixMapping
+	}{
+		{ // 0
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  suffix eth.link
+			}`,
+			"Testfile:4 - Error during parsing: invalid suffix; requires DNS suffix and ENS suffix",
+			nil,
+		},
+		{ // 1
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  suffix eth.link eth
+			  suffix ETH.limo. eth.
+			}`,
+			"",
+			[]suffixMapping{{dns: "eth.link.", ens: "eth."}, {dns: "eth.limo.", ens: "eth."}},
+		},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("ens", test.inputFileRules)
+		cfg, err := ensParse(c)
+		if test.err != "" {
+			if err == nil {
+				t.Fatalf("Failed to obtain expected error at test %d", i)
+			}
+			if err.Error() != test.err {
+				t.Fatalf("Unexpected error \"%s\" at test %d", err.Error(), i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error \"%s\" at test %d", err.Error(), i)
+		}
+		if len(cfg.suffixMappings) != len(test.suffixes) {
+			t.Fatalf("Test %d suffixes expected %v entries, got %v", i, len(test.suffixes), len(cfg.suffixMappings))
+		}
+		for j := range test.suffixes {
+			if cfg.suffixMappings[j] != test.suffixes[j] {
+				t.Fatalf("Test %d suffixes expected %v, got %v", i, test.suffixes[j], cfg.suffixMappings[j])
+			}
+		}
+	}
+}
+
+func TestENSParseZones(t *testing.T) {
+	tests := []struct {
+		inputFileRules string
+		zones          []string
+		fall           []string
+	}{
+		{ // 0
+			`ens eth.link. ETH.limo {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			}`,
+			[]string{"eth.link.", "eth.limo."},
+			nil,
+		},
+		{ // 1
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  fallthrough
+			}`,
+			[]string{"example.org."},
+			[]string{"."},
+		},
+		{ // 2
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  fallthrough eth.limo
+			}`,
+			[]string{"example.org."},
+			[]string{"eth.limo."},
+		},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("ens", test.inputFileRules)
+		c.ServerBlockKeys = []string{"example.org:53"}
+		cfg, err := ensParse(c)
+		if err != nil {
+			t.Fatalf("Unexpected error \"%s\" at test %d", err.Error(), i)
+		}
+		if strings.Join(cfg.zones, " ") != strings.Join(test.zones, " ") {
+			t.Fatalf("Test %d zones expected %v, got %v", i, test.zones, cfg.zones)
+		}
+		if strings.Join(cfg.fall.Zones, " ") != strings.Join(test.fall, " ") {
+			t.Fatalf("Test %d fallthrough expected %v, got %v", i, test.fall, cfg.fall.Zones)
+		}
+	}
+}
+
+func TestENSParseQueryLog(t *testing.T) {
+	c := caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	  querylog
+	}`)
+	cfg, err := ensParse(c)
+	if err != nil {
+		t.Fatalf("Unexpected error \"%s\"", err.Error())
+	}
+	if !cfg.queryLog {
+		t.Fatalf("Query log not enabled")
+	}
+
+	c = caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	  querylog json
+	}`)
+	if _, err := ensParse(c); err == nil || err.Error() != "Testfile:4 - Error during parsing: invalid querylog; takes no value" {
+		t.Fatalf("Unexpected error \"%v\"", err)
+	}
+}
+
+func TestENSParseMaxBlockLag(t *testing.T) {
+	tests := []struct {
+		inputFileRules string
+		err            string
+		maxblocklag    time.Duration
+	}{
+		{ // 0
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			}`,
+			"",
+			defaultMaxBlockLag,
+		},
+		{ // 1
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  maxblocklag 30s
+			}`,
+			"",
+			30 * time.Second,
+		},
+		{ // 2
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  maxblocklag soon
+			}`,
+			"Testfile:4 - Error during parsing: invalid maxblocklag; bad duration soon",
+			0,
+		},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("ens", test.inputFileRules)
+		cfg, err := ensParse(c)
+		if test.err != "" {
+			if err == nil {
+				t.Fatalf("Failed to obtain expected error at test %d", i)
+			}
+			if err.Error() != test.err {
+				t.Fatalf("Unexpected error \"%s\" at test %d", err.Error(), i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error \"%s\" at test %d", err.Error(), i)
+		}
+		if cfg.maxBlockLag != test.maxblocklag {
+			t.Fatalf("Test %d maxblocklag expected %v, got %v", i, test.maxblocklag, cfg.maxBlockLag)
+		}
+	}
+}
+
+func TestENSParseTimeouts(t *testing.T) {
+	c := caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	}`)
+	cfg, err := ensParse(c)
+	if err != nil {
+		t.Fatalf("Unexpected error \"%s\"", err.Error())
+	}
+	if cfg.lookupTimeout != defaultLookupTimeout || cfg.rpcTimeout != defaultRPCTimeout {
+		t.Fatalf("Unexpected default timeouts %v %v", cfg.lookupTimeout, cfg.rpcTimeout)
+	}
+
+	c = caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	  lookuptimeout 3s
+	  rpctimeout 500ms
+	}`)
+	cfg, err = ensParse(c)
+	if err != nil {
+		t.Fatalf("Unexpected error \"%s\"", err.Error())
+	}
+	if cfg.lookupTimeout != 3*time.Second || cfg.rpcTimeout != 500*time.Millisecond {
+		t.Fatalf("Unexpected timeouts %v %v", cfg.lookupTimeout, cfg.rpcTimeout)
+	}
+
+	c = caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	  rpctimeout 1s 2s
+	}`)
+	if _, err := ensParse(c); err == nil || err.Error() != "Testfile:4 - Error during parsing: invalid rpctimeout; requires a single value" {
+		t.Fatalf("Unexpected error \"%v\"", err)
+	}
+}
+
+func TestENSParseBlocklist(t *testing.T) {
+	tests := []struct {
+		inputFileRules string
+		err            string
+		blocklists     []string
+		action         blockAction
+		sinkholes      int
+	}{
+		{ // 0
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  blocklist phishing.txt sanctions.txt
+			}`,
+			"",
+			[]string{"phishing.txt", "sanctions.txt"},
+			blockNXDomain,
+			0,
+		},
+		{ // 1
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  blocklist phishing.txt
+			  blockaction refuse
+			}`,
+			"",
+			[]string{"phishing.txt"},
+			blockRefuse,
+			0,
+		},
+		{ // 2
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  blocklist phishing.txt
+			  blockaction sinkhole 192.0.2.1 2001:db8::1
+			}`,
+			"",
+			[]string{"phishing.txt"},
+			blockSinkhole,
+			2,
+		},
+		{ // 3
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  blockaction sinkhole
+			}`,
+			"Testfile:4 - Error during parsing: invalid blockaction; sinkhole requires addresses",
+			nil,
+			blockNXDomain,
+			0,
+		},
+		{ // 4
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  blockaction sinkhole 192.0.2
+			}`,
+			"Testfile:4 - Error during parsing: invalid blockaction; bad address 192.0.2",
+			nil,
+			blockNXDomain,
+			0,
+		},
+		{ // 5
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  blockaction drop
+			}`,
+			"Testfile:4 - Error during parsing: invalid blockaction; unknown action drop",
+			nil,
+			blockNXDomain,
+			0,
+		},
+		{ // 6
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  blocklist
+			}`,
+			"Testfile:4 - Error during parsing: invalid blocklist; no value",
+			nil,
+			blockNXDomain,
+			0,
+		},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("ens", test.inputFileRules)
+		cfg, err := ensParse(c)
+		if test.err != "" {
+			if err == nil {
+				t.Fatalf("Failed to obtain expected error at test %d", i)
+			}
+			if err.Error() != test.err {
+				t.Fatalf("Unexpected error \"%s\" at test %d", err.Error(), i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error \"%s\" at test %d", err.Error(), i)
+		}
+		if strings.Join(cfg.blocklists, " ") != strings.Join(test.blocklists, " ") {
+			t.Fatalf("Test %d blocklists expected %v, got %v", i, test.blocklists, cfg.blocklists)
+		}
+		if cfg.blockAction != test.action {
+			t.Fatalf("Test %d action expected %v, got %v", i, test.action, cfg.blockAction)
+		}
+		if len(cfg.sinkholeAs)+len(cfg.sinkholeAAAAs) != test.sinkholes {
+			t.Fatalf("Test %d sinkholes expected %d, got %v %v", i, test.sinkholes, cfg.sinkholeAs, cfg.sinkholeAAAAs)
+		}
+	}
+}
+
+func TestENSParseExpiry(t *testing.T) {
+	c := caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	}`)
+	cfg, err := ensParse(c)
+	if err != nil {
+		t.Fatalf("Unexpected error \"%s\"", err.Error())
+	}
+	if cfg.expiryGrace != 0 || cfg.nameWrapper != defaultNameWrapper {
+		t.Fatalf("Unexpected defaults %v %v", cfg.expiryGrace, cfg.nameWrapper.Hex())
+	}
+
+	c = caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	  expirygrace 720h
+	  namewrapper 0x0635513f179D50A207757E05759CbD106d7dFcE8
+	}`)
+	cfg, err = ensParse(c)
+	if err != nil {
+		t.Fatalf("Unexpected error \"%s\"", err.Error())
+	}
+	if cfg.expiryGrace != 720*time.Hour || cfg.nameWrapper.Hex() != "0x0635513f179D50A207757E05759CbD106d7dFcE8" {
+		t.Fatalf("Unexpected values %v %v", cfg.expiryGrace, cfg.nameWrapper.Hex())
+	}
+
+	c = caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	  namewrapper wrapper.eth
+	}`)
+	if _, err := ensParse(c); err == nil || err.Error() != "Testfile:4 - Error during parsing: invalid namewrapper; bad address wrapper.eth" {
+		t.Fatalf("Unexpected error \"%v\"", err)
+	}
+}
+
+func TestENSParseHTTPS(t *testing.T) {
+	tests := []struct {
+		inputFileRules string
+		err            string
+		alpn           []string
+		port           uint16
+		hints          bool
+	}{
+		{ // 0
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			}`,
+			"",
+			nil,
+			0,
+			false,
+		},
+		{ // 1
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  httpsalpn h2 http/1.1
+			  httpsport 8443
+			  httpshints
+			}`,
+			"",
+			[]string{"h2", "http/1.1"},
+			8443,
+			true,
+		},
+		{ // 2
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  httpsalpn
+			}`,
+			"Testfile:4 - Error during parsing: invalid httpsalpn; no value",
+			nil,
+			0,
+			false,
+		},
+		{ // 3
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  httpsport 65536
+			}`,
+			"Testfile:4 - Error during parsing: invalid httpsport; bad port 65536",
+			nil,
+			0,
+			false,
+		},
+		{ // 4
+			`ens {
+			  connection http://localhost:8545/
+			  ethlinknameservers ns1.ethdns.xyz
+			  httpshints yes
+			}`,
+			"Testfile:4 - Error during parsing: invalid httpshints; takes no value",
+			nil,
+			0,
+			false,
+		},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("ens", test.inputFileRules)
+		cfg, err := ensParse(c)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Fatalf("Unexpected error \"%v\" at test %d", err, i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error \"%s\" at test %d", err.Error(), i)
+		}
+		if strings.Join(cfg.httpsALPN, ",") != strings.Join(test.alpn, ",") {
+			t.Errorf("httpsalpn %v does not match expected %v at test %d", cfg.httpsALPN, test.alpn, i)
+		}
+		if cfg.httpsPort != test.port {
+			t.Errorf("httpsport %d does not match expected %d at test %d", cfg.httpsPort, test.port, i)
+		}
+		if cfg.httpsHints != test.hints {
+			t.Errorf("httpshints %t does not match expected %t at test %d", cfg.httpsHints, test.hints, i)
+		}
+	}
+}
+
+func TestENSParseSubdomainGateway(t *testing.T) {
+	c := caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	  subdomaingateway Dweb.Link
+	}`)
+	cfg, err := ensParse(c)
+	if err != nil {
+		t.Fatalf("Unexpected error \"%s\"", err.Error())
+	}
+	if cfg.subdomainGateway != "dweb.link." {
+		t.Fatalf("Unexpected subdomain gateway %s", cfg.subdomainGateway)
+	}
+
+	c = caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	  subdomaingateway dweb.link ipfs.io
+	}`)
+	if _, err := ensParse(c); err == nil || err.Error() != "Testfile:4 - Error during parsing: invalid subdomaingateway; requires a single value" {
+		t.Fatalf("Unexpected error \"%v\"", err)
+	}
+}
+
+func TestENSParseGatewayCheck(t *testing.T) {
+	c := caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	}`)
+	cfg, err := ensParse(c)
+	if err != nil {
+		t.Fatalf("Unexpected error \"%s\"", err.Error())
+	}
+	if cfg.gatewayCheckPort != 0 || cfg.gatewayCheckInterval != defaultGatewayCheckInterval ||
+		cfg.gatewayUnhealthyThreshold != defaultGatewayUnhealthyThreshold || cfg.gatewayHealthyThreshold != defaultGatewayHealthyThreshold {
+		t.Fatalf("Unexpected defaults %d %v %d %d", cfg.gatewayCheckPort, cfg.gatewayCheckInterval, cfg.gatewayUnhealthyThreshold, cfg.gatewayHealthyThreshold)
+	}
+
+	c = caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	  gatewaycheck 8080 bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq
+	  gatewaycheckinterval 30s
+	  gatewaycheckthresholds 5 1
+	}`)
+	cfg, err = ensParse(c)
+	if err != nil {
+		t.Fatalf("Unexpected error \"%s\"", err.Error())
+	}
+	if cfg.gatewayCheckPort != 8080 || cfg.gatewayCheckCID != "bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq" ||
+		cfg.gatewayCheckInterval != 30*time.Second || cfg.gatewayUnhealthyThreshold != 5 || cfg.gatewayHealthyThreshold != 1 {
+		t.Fatalf("Unexpected values %d %s %v %d %d", cfg.gatewayCheckPort, cfg.gatewayCheckCID, cfg.gatewayCheckInterval, cfg.gatewayUnhealthyThreshold, cfg.gatewayHealthyThreshold)
+	}
+
+	c = caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	  gatewaycheckthresholds 0 1
+	}`)
+	if _, err := ensParse(c); err == nil || err.Error() != "Testfile:4 - Error during parsing: invalid gatewaycheckthresholds; bad threshold 0" {
+		t.Fatalf("Unexpected error \"%v\"", err)
+	}
+}
+
+func TestENSParseGatewayPools(t *testing.T) {
+	c := caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	  gatewaypool eu 192.0.2.1 2001:db8::1
+	  gatewaypool office 192.0.2.2
+	  gatewaysubnet office 10.0.0.0/8 fd00::/8
+	  gatewaygeoip GeoLite2-Country.mmdb
+	  gatewaygeo eu eu gb
+	}`)
+	cfg, err := ensParse(c)
+	if err != nil {
+		t.Fatalf("Unexpected error \"%s\"", err.Error())
+	}
+	if len(cfg.gatewayPools) != 2 || strings.Join(cfg.gatewayPools["eu"], ",") != "192.0.2.1,2001:db8::1" {
+		t.Fatalf("Unexpected pools %v", cfg.gatewayPools)
+	}
+	if len(cfg.gatewaySubnets) != 2 || cfg.gatewaySubnets[1].subnet.String() != "fd00::/8" || cfg.gatewaySubnets[1].pool != "office" {
+		t.Fatalf("Unexpected subnets %v", cfg.gatewaySubnets)
+	}
+	if cfg.gatewayGeoIP != "GeoLite2-Country.mmdb" || cfg.gatewayGeoPools["EU"] != "eu" || cfg.gatewayGeoPools["GB"] != "eu" {
+		t.Fatalf("Unexpected locations %s %v", cfg.gatewayGeoIP, cfg.gatewayGeoPools)
+	}
+
+	tests := []struct {
+		rules string
+		err   string
+	}{
+		{
+			`gatewaypool eu bad`,
+			"Testfile:4 - Error during parsing: invalid gatewaypool; bad address bad",
+		},
+		{
+			`gatewaysubnet eu 10.0.0.0/33`,
+			"Testfile:4 - Error during parsing: invalid gatewaysubnet; bad subnet 10.0.0.0/33",
+		},
+		{
+			`gatewaysubnet eu 10.0.0.0/8`,
+			"Testfile:5 - Error during parsing: invalid gatewaysubnet; unknown pool eu",
+		},
+		{
+			`gatewaygeo eu de`,
+			"Testfile:5 - Error during parsing: invalid gatewaygeo; unknown pool eu",
+		},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("ens", `ens {
+		  connection http://localhost:8545/
+		  ethlinknameservers ns1.ethdns.xyz
+		  `+test.rules+`
+		}`)
+		if _, err := ensParse(c); err == nil || err.Error() != test.err {
+			t.Fatalf("Unexpected error \"%v\" at test %d", err, i)
+		}
+	}
+}
+
+func TestENSParseGatewayOrder(t *testing.T) {
+	c := caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	  gatewayorder weighted
+	  gatewayweight 3 176.9.154.81 2a01:4f8:160:4069::2
+	  gatewaymax 2
+	}`)
+	cfg, err := ensParse(c)
+	if err != nil {
+		t.Fatalf("Unexpected error \"%s\"", err.Error())
+	}
+	if cfg.gatewayOrder != gatewayOrderWeighted || cfg.gatewayWeights["176.9.154.81"] != 3 || cfg.gatewayWeights["2a01:4f8:160:4069::2"] != 3 || cfg.gatewayMax != 2 {
+		t.Fatalf("Unexpected values %v %v %d", cfg.gatewayOrder, cfg.gatewayWeights, cfg.gatewayMax)
+	}
+
+	tests := []struct {
+		rules string
+		err   string
+	}{
+		{
+			`gatewayorder random`,
+			"Testfile:4 - Error during parsing: invalid gatewayorder; unknown order random",
+		},
+		{
+			`gatewayweight 0 176.9.154.81`,
+			"Testfile:4 - Error during parsing: invalid gatewayweight; bad weight 0",
+		},
+		{
+			`gatewayweight 2 176.9.154.81`,
+			"Testfile:5 - Error during parsing: invalid gatewayweight; requires gatewayorder weighted",
+		},
+		{
+			`gatewaymax none`,
+			"Testfile:4 - Error during parsing: invalid gatewaymax; bad value none",
+		},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("ens", `ens {
+		  connection http://localhost:8545/
+		  ethlinknameservers ns1.ethdns.xyz
+		  `+test.rules+`
+		}`)
+		if _, err := ensParse(c); err == nil || err.Error() != test.err {
+			t.Fatalf("Unexpected error \"%v\" at test %d", err, i)
+		}
+	}
+}
+
+func TestENSParseTTLs(t *testing.T) {
+	c := caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	  ttl a 60
+	  ttl SOA 300
+	  ttlrecord
+	}`)
+	cfg, err := ensParse(c)
+	if err != nil {
+		t.Fatalf("Unexpected error \"%s\"", err.Error())
+	}
+	if len(cfg.ttls) != 2 || cfg.ttls[dns.TypeA] != 60 || cfg.ttls[dns.TypeSOA] != 300 || cfg.ttlRecord != "dns.ttl" {
+		t.Fatalf("Unexpected values %v %s", cfg.ttls, cfg.ttlRecord)
+	}
+
+	tests := []struct {
+		rules string
+		err   string
+	}{
+		{
+			`ttl mx 60`,
+			"Testfile:4 - Error during parsing: invalid ttl; unsupported record type mx",
+		},
+		{
+			`ttl a -1`,
+			"Testfile:4 - Error during parsing: invalid ttl; bad value -1",
+		},
+		{
+			`ttlrecord dns.ttl ttl`,
+			"Testfile:4 - Error during parsing: invalid ttlrecord; multiple values",
+		},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("ens", `ens {
+		  connection http://localhost:8545/
+		  ethlinknameservers ns1.ethdns.xyz
+		  `+test.rules+`
+		}`)
+		if _, err := ensParse(c); err == nil || err.Error() != test.err {
+			t.Fatalf("Unexpected error \"%v\" at test %d", err, i)
+		}
+	}
+}
+
+func TestENSParseACME(t *testing.T) {
+	c := caddy.NewTestController("ens", `ens {
+	  connection http://localhost:8545/
+	  ethlinknameservers ns1.ethdns.xyz
+	  caa 0 issue letsencrypt.org
+	  caa 0 issuewild ";"
+	  acmechallenges challenges.txt
+	  acmelisten 127.0.0.1:8053
+	}`)
+	cfg, err := ensParse(c)
+	if err != nil {
+		t.Fatalf("Unexpected error \"%s\"", err.Error())
+	}
+	if len(cfg.caas) != 2 || cfg.caas[0].Tag != "issue" || cfg.caas[0].Value != "letsencrypt.org" || cfg.caas[1].Value != ";" {
+		t.Fatalf("Unexpected CAA records %v", cfg.caas)
+	}
+	if cfg.acmeChallenges != "challenges.txt" || cfg.acmeListen != "127.0.0.1:8053" {
+		t.Fatalf("Unexpected values %s %s", cfg.acmeChallenges, cfg.acmeListen)
+	}
+
+	tests := []struct {
+		rules string
+		err   string
+	}{
+		{
+			`caa 256 issue letsencrypt.org`,
+			"Testfile:4 - Error during parsing: invalid caa; bad flag 256",
+		},
+		{
+			`caa 0 is-sue letsencrypt.org`,
+			"Testfile:4 - Error during parsing: invalid caa; bad tag is-sue",
+		},
+		{
+			`acmelisten 8053`,
+			"Testfile:4 - Error during parsing: invalid acmelisten; bad address 8053",
+		},
+		{
+			`acmelisten 0.0.0.0:8053`,
+			"Testfile:4 - Error during parsing: invalid acmelisten; address 0.0.0.0:8053 is not local",
+		},
+	}
+	for i, test := range tests {
+		c := caddy.NewTestController("ens", `ens {
+		  connection http://localhost:8545/
+		  ethlinknameservers ns1.ethdns.xyz
+		  `+test.rules+`
+		}`)
+		if _, err := ensParse(c); err == nil || err.Error() != test.err {
+			t.Fatalf("Unexpected error \"%v\" at test %d", err, i)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package ens
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/miekg/dns"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multihash"
+)
+
+// subdomainGatewayNamespaces are the namespaces at a subdomain gateway for the
+// contenthash codecs that it serves.
+var subdomainGatewayNamespaces = map[string]string{
+	"ipfs-ns": "ipfs",
+	"ipns-ns": "ipns",
+}
+
+// gatewayCNAME creates a CNAME record pointing a name at the subdomain
+// gateway name for its contenthash.  nil is returned if there is no subdomain
+// gateway or the contenthash cannot be served by one.
+func (e ENS) gatewayCNAME(ctx context.Context, name string, domain string, contentHash []byte) dns.RR {
+	if e.SubdomainGateway == "" {
+		return nil
+	}
+	codec, err := contenthashCodec(contentHash)
+	if err != nil {
+		return nil
+	}
+	namespace, exists := subdomainGatewayNamespaces[codec]
+	if !exists {
+		return nil
+	}
+	label, err := subdomainGatewayLabel(contentHash)
+	if err != nil {
+		log.Warningf("cannot serve contenthash of %s from subdomain gateway: %v", name, err)
+		return nil
+	}
+	return &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: e.ttl(ctx, domain, dns.TypeCNAME)},
+		Target: fmt.Sprintf("%s.%s.%s", label, namespace, e.SubdomainGateway),
+	}
+}
+
+// subdomainGatewayLabel returns the label that identifies the content of an
+// IPFS or IPNS contenthash at a subdomain gateway.  IPFS content is identified
+// by its CIDv1 in base32, IPNS keys by their CIDv1 in base36 and IPNS DNSLink
+// names by the name with its dots replaced by hyphens.
+func subdomainGatewayLabel(contentHash []byte) (string, error) {
+	codec, err := contenthashCodec(contentHash)
+	if err != nil {
+		return "", err
+	}
+	_, size := binary.Uvarint(contentHash)
+	content, err := cid.Cast(contentHash[size:])
+	if err != nil {
+		return "", err
+	}
+
+	var label string
+	switch codec {
+	case "ipfs-ns":
+		label, err = cid.NewCidV1(content.Type(), content.Hash()).StringOfBase(multibase.Base32)
+	case "ipns-ns":
+		if name, isName := dnsLinkName(content); isName {
+			// Inline the name, escaping the hyphens it already contains.
+			label = strings.ReplaceAll(strings.ReplaceAll(name, "-", "--"), ".", "-")
+		} else {
+			label, err = cid.NewCidV1(cid.Libp2pKey, content.Hash()).StringOfBase(multibase.Base36)
+		}
+	default:
+		return "", fmt.Errorf("unsupported codec %s", codec)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(label) > 63 {
+		return "", errors.New("content identifier too long for a DNS label")
+	}
+	return label, nil
+}
+
+// dnsLinkName returns the DNSLink name held in an IPNS CID, if it holds one
+// rather than a key.  Names are held as identity multihashes.
+func dnsLinkName(content cid.Cid) (string, bool) {
+	decoded, err := multihash.Decode(content.Hash())
+	if err != nil || decoded.Code != multihash.IDENTITY {
+		return "", false
+	}
+	name := strings.ToLower(string(decoded.Digest))
+	if !strings.Contains(name, ".") {
+		return "", false
+	}
+	if _, isDomain := dns.IsDomainName(name); !isDomain {
+		return "", false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' && c != '.' {
+			return "", false
+		}
+	}
+	return name, true
+}
@@ -0,0 +1,90 @@
+package ens
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	ens "github.com/wealdtech/go-ens/v3"
+)
+
+func TestSubdomainGatewayLabel(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentHash string
+		rawHash     []byte
+		label       string
+		err         bool
+	}{
+		{
+			name:        "IPFSv0",
+			contentHash: "ipfs://QmXoypizjW3WknFiJnKLwHCnL72vedxjQkDDP1mXWo6uco",
+			label:       "bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq",
+		},
+		{
+			name:        "IPFSv1",
+			contentHash: "ipfs://bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq",
+			label:       "bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq",
+		},
+		{
+			name:        "IPNSKey",
+			contentHash: "ipns://k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8",
+			label:       "k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8",
+		},
+		{
+			name:    "IPNSName",
+			rawHash: append([]byte{0xe5, 0x01, 0x01, 0x72, 0x00, 0x12}, []byte("app.my-uniswap.org")...),
+			label:   "app-my--uniswap-org",
+		},
+		{
+			name:        "Swarm",
+			contentHash: "bzz://d1de9994b4d039f6548d191eb26786769f580809256b4685ef316805265ea162",
+			err:         true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			contentHash := test.rawHash
+			if test.contentHash != "" {
+				var err error
+				contentHash, err = ens.StringToContenthash(test.contentHash)
+				if err != nil {
+					t.Fatalf("Failed to create contenthash: %v", err)
+				}
+			}
+			label, err := subdomainGatewayLabel(contentHash)
+			if test.err {
+				if err == nil {
+					t.Fatalf("Expected error not returned")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if label != test.label {
+				t.Fatalf("Unexpected label %s (expected %s)", label, test.label)
+			}
+		})
+	}
+}
+
+func TestGatewayCNAME(t *testing.T) {
+	contentHash, err := ens.StringToContenthash("ipfs://QmXoypizjW3WknFiJnKLwHCnL72vedxjQkDDP1mXWo6uco")
+	if err != nil {
+		t.Fatalf("Failed to create contenthash: %v", err)
+	}
+
+	if rr := (ENS{}).gatewayCNAME(context.Background(), "wealdtech.eth.", "wealdtech.eth.", contentHash); rr != nil {
+		t.Fatalf("Unexpected record %v with no subdomain gateway", rr)
+	}
+
+	rr := ENS{SubdomainGateway: "dweb.link."}.gatewayCNAME(context.Background(), "wealdtech.eth.", "wealdtech.eth.", contentHash)
+	if rr == nil {
+		t.Fatalf("No record returned")
+	}
+	if target := rr.(*dns.CNAME).Target; target != "bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq.ipfs.dweb.link." {
+		t.Fatalf("Unexpected target %s", target)
+	}
+}
@@ -0,0 +1,76 @@
+package ens
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// suffixMapping maps a DNS suffix to an ENS suffix, for example eth.link to
+// eth, allowing ENS names to be served under DNS domains.
+type suffixMapping struct {
+	dns string
+	ens string
+}
+
+// toENS translates a name under the DNS suffix to a name under the ENS suffix.
+func (m *suffixMapping) toENS(name string) string {
+	return translateSuffix(name, m.dns, m.ens)
+}
+
+// toDNS translates a name under the ENS suffix to a name under the DNS suffix.
+func (m *suffixMapping) toDNS(name string) string {
+	return translateSuffix(name, m.ens, m.dns)
+}
+
+// translateSuffix replaces the from suffix of a name with the to suffix.
+// Names without the from suffix are returned unaltered.
+func translateSuffix(name string, from string, to string) string {
+	if !dns.IsSubDomain(from, name) {
+		return name
+	}
+	return name[:len(name)-len(from)] + to
+}
+
+// suffixMappingFor returns the suffix mapping that applies to the given
+// DNS name, or nil if there is none.  The longest matching suffix wins.
+func (e ENS) suffixMappingFor(name string) *suffixMapping {
+	var res *suffixMapping
+	for i := range e.SuffixMappings {
+		if dns.IsSubDomain(e.SuffixMappings[i].dns, name) &&
+			(res == nil || len(e.SuffixMappings[i].dns) > len(res.dns)) {
+			res = &e.SuffixMappings[i]
+		}
+	}
+	return res
+}
+
+// toDNSRRs translates owner names and any names within the data of the
+// given resource records from the ENS suffix to the DNS suffix.
+func (m *suffixMapping) toDNSRRs(rrs []dns.RR) {
+	for _, rr := range rrs {
+		rr.Header().Name = m.toDNS(rr.Header().Name)
+		switch v := rr.(type) {
+		case *dns.CNAME:
+			v.Target = m.toDNS(v.Target)
+		case *dns.DNAME:
+			v.Target = m.toDNS(v.Target)
+		case *dns.NS:
+			v.Ns = m.toDNS(v.Ns)
+		case *dns.MX:
+			v.Mx = m.toDNS(v.Mx)
+		case *dns.SRV:
+			v.Target = m.toDNS(v.Target)
+		case *dns.PTR:
+			v.Ptr = m.toDNS(v.Ptr)
+		case *dns.SOA:
+			v.Ns = m.toDNS(v.Ns)
+			v.Mbox = m.toDNS(v.Mbox)
+		}
+	}
+}
+
+// normalizeSuffix lower-cases a suffix and ensures it is fully qualified.
+func normalizeSuffix(suffix string) string {
+	return dns.Fqdn(strings.ToLower(strings.Trim(suffix, ".")))
+}
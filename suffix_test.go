@@ -0,0 +1,74 @@
+package ens
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSuffixMappingFor(t *testing.T) {
+	e := ENS{SuffixMappings: []suffixMapping{
+		{dns: "eth.link.", ens: "eth."},
+		{dns: "eth.limo.", ens: "eth."},
+		{dns: "gno.link.", ens: "gno."},
+		{dns: "test.eth.link.", ens: "test."},
+	}}
+
+	tests := []struct {
+		name string
+		ens  string
+	}{
+		{"wealdtech.eth.link.", "wealdtech.eth."},
+		{"www.wealdtech.eth.limo.", "www.wealdtech.eth."},
+		{"eth.link.", "eth."},
+		{"wealdtech.gno.link.", "wealdtech.gno."},
+		{"foo.test.eth.link.", "foo.test."},
+		{"wealdtech.eth.", ""},
+		{"badeth.link.", ""},
+	}
+
+	for i, tt := range tests {
+		mapping := e.suffixMappingFor(tt.name)
+		if mapping == nil {
+			if tt.ens != "" {
+				t.Errorf("Test %d: no mapping for %s", i, tt.name)
+			}
+			continue
+		}
+		if tt.ens == "" {
+			t.Errorf("Test %d: unexpected mapping for %s", i, tt.name)
+			continue
+		}
+		if ens := mapping.toENS(tt.name); ens != tt.ens {
+			t.Errorf("Test %d: %s => %s (expected %s)", i, tt.name, ens, tt.ens)
+		}
+		if name := mapping.toDNS(tt.ens); name != tt.name {
+			t.Errorf("Test %d: %s => %s (expected %s)", i, tt.ens, name, tt.name)
+		}
+	}
+}
+
+func TestSuffixMappingToDNSRRs(t *testing.T) {
+	mapping := &suffixMapping{dns: "eth.link.", ens: "eth."}
+	rrs := []dns.RR{
+		newRR("www.wealdtech.eth. 3600 IN CNAME wealdtech.eth."),
+		newRR("foo.wealdtech.eth. 3600 IN DNAME bar.wealdtech.eth."),
+		newRR("wealdtech.eth. 3600 IN NS ns1.ethdns.xyz."),
+		newRR("wealdtech.eth. 3600 IN MX 10 mail.wealdtech.eth."),
+		newRR("ns1.ethdns.xyz. 10800 IN SOA ns1.ethdns.xyz. hostmaster.wealdtech.eth. 2019010100 3600 600 1209600 300"),
+	}
+	expected := []dns.RR{
+		newRR("www.wealdtech.eth.link. 3600 IN CNAME wealdtech.eth.link."),
+		newRR("foo.wealdtech.eth.link. 3600 IN DNAME bar.wealdtech.eth.link."),
+		newRR("wealdtech.eth.link. 3600 IN NS ns1.ethdns.xyz."),
+		newRR("wealdtech.eth.link. 3600 IN MX 10 mail.wealdtech.eth.link."),
+		newRR("ns1.ethdns.xyz. 10800 IN SOA ns1.ethdns.xyz. hostmaster.wealdtech.eth.link. 2019010100 3600 600 1209600 300"),
+	}
+
+	mapping.toDNSRRs(rrs)
+	for i := range rrs {
+		if rrs[i].String() != expected[i].String() {
+			t.Errorf("Test %d: %s (expected %s)", i, rrs[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package ens
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// textRecordsLabel is the label that, when prefixed to a domain, requests
+// all exposed text records for that domain.
+const textRecordsLabel = "_text"
+
+// textRecordLabel is the label that, when prefixed to a key and a domain,
+// requests a single text record for that domain.
+const textRecordLabel = "_ens"
+
+// textRecordKeys returns the ENS text record keys requested by the given
+// name within a domain, or nil if the name is not a request for text records.
+// Only keys in the configured allowlist are returned.
+func (e ENS) textRecordKeys(name string, domain string) []string {
+	if len(e.TextRecords) == 0 {
+		return nil
+	}
+	if name == textRecordsLabel+"."+domain {
+		return e.TextRecords
+	}
+	prefix := textRecordLabel + "."
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, "."+domain) {
+		return nil
+	}
+	key := strings.TrimSuffix(strings.TrimPrefix(name, prefix), "."+domain)
+	for _, textRecord := range e.TextRecords {
+		// Names are lower-cased prior to lookup so compare insensitively.
+		if strings.EqualFold(textRecord, key) {
+			return []string{textRecord}
+		}
+	}
+	return nil
+}
+
+// handleTextRecords returns TXT records containing the values of the given
+// ENS text records.  A request for all text records returns each value as
+// key=value, a request for a single text record returns the value alone.
+func (e ENS) handleTextRecords(ctx context.Context, name string, domain string, keys []string) ([]dns.RR, error) {
+	results := make([]dns.RR, 0)
+
+	ethDomain := strings.TrimSuffix(domain, ".")
+	resolver, err := e.getResolver(ctx, ethDomain, capText)
+	if err != nil {
+		return results, nil
+	}
+
+	all := name == textRecordsLabel+"."+domain
+	ttl := e.ttl(ctx, domain, dns.TypeTXT)
+	for _, key := range keys {
+		value, err := text(ctx, resolver, ethDomain, key)
+		if err != nil {
+			// Resolvers without text record support fail here; that isn't an error for us.
+			log.Debugf("error obtaining text record %s for %s: %v", key, ethDomain, err)
+			continue
+		}
+		if value == "" {
+			continue
+		}
+		if all {
+			value = fmt.Sprintf("%s=%s", key, value)
+		}
+		results = append(results, &dns.TXT{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl},
+			Txt: splitTXT(value),
+		})
+	}
+
+	return results, nil
+}
+
+// splitTXT splits a value in to strings that fit within the 255-octet
+// limit of a single TXT character-string.
+func splitTXT(value string) []string {
+	res := make([]string, 0, len(value)/255+1)
+	for len(value) > 255 {
+		res = append(res, value[:255])
+		value = value[255:]
+	}
+	return append(res, value)
+}
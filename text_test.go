@@ -0,0 +1,46 @@
+package ens
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTextRecordKeys(t *testing.T) {
+	e := ENS{TextRecords: []string{"url", "email", "com.twitter"}}
+
+	tests := []struct {
+		name   string
+		domain string
+		keys   []string
+	}{
+		{"wealdtech.eth.", "wealdtech.eth.", nil},
+		{"_text.wealdtech.eth.", "wealdtech.eth.", []string{"url", "email", "com.twitter"}},
+		{"_ens.url.wealdtech.eth.", "wealdtech.eth.", []string{"url"}},
+		{"_ens.com.twitter.wealdtech.eth.", "wealdtech.eth.", []string{"com.twitter"}},
+		{"_ens.avatar.wealdtech.eth.", "wealdtech.eth.", nil},
+		{"_ens.wealdtech.eth.", "wealdtech.eth.", nil},
+		{"_text.sub.wealdtech.eth.", "wealdtech.eth.", nil},
+	}
+
+	for i, tt := range tests {
+		keys := e.textRecordKeys(tt.name, tt.domain)
+		if strings.Join(keys, ",") != strings.Join(tt.keys, ",") || (keys == nil) != (tt.keys == nil) {
+			t.Errorf("Test %d: %s => %v (expected %v)", i, tt.name, keys, tt.keys)
+		}
+	}
+
+	if keys := (ENS{}).textRecordKeys("_text.wealdtech.eth.", "wealdtech.eth."); keys != nil {
+		t.Errorf("Text records returned when none configured: %v", keys)
+	}
+}
+
+func TestSplitTXT(t *testing.T) {
+	value := strings.Repeat("a", 600)
+	parts := splitTXT(value)
+	if len(parts) != 3 || len(parts[0]) != 255 || len(parts[1]) != 255 || len(parts[2]) != 90 {
+		t.Errorf("Unexpected split of 600 octets: %d parts", len(parts))
+	}
+	if parts := splitTXT("short"); len(parts) != 1 || parts[0] != "short" {
+		t.Errorf("Unexpected split of short value: %v", parts)
+	}
+}
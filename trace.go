@@ -0,0 +1,19 @@
+package ens
+
+import (
+	"context"
+
+	ot "github.com/opentracing/opentracing-go"
+)
+
+// startSpan starts a span as a child of the span in the context, as set up
+// by the trace plugin, and returns it along with a context that carries it.
+// If the context has no span a no-op span is returned.
+func startSpan(ctx context.Context, name string) (ot.Span, context.Context) {
+	parent := ot.SpanFromContext(ctx)
+	if parent == nil {
+		return ot.NoopTracer{}.StartSpan(name), ctx
+	}
+	span := parent.Tracer().StartSpan(name, ot.ChildOf(parent.Context()))
+	return span, ot.ContextWithSpan(ctx, span)
+}
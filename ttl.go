@@ -0,0 +1,169 @@
+package ens
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/miekg/dns"
+)
+
+// defaultTTLs are the default TTLs of synthesized records by type.
+var defaultTTLs = map[uint16]uint32{
+	dns.TypeSOA:   10800,
+	dns.TypeNS:    3600,
+	dns.TypeTXT:   3600,
+	dns.TypeA:     3600,
+	dns.TypeAAAA:  3600,
+	dns.TypeCNAME: 3600,
+	dns.TypeHTTPS: 3600,
+	dns.TypeSVCB:  3600,
+	dns.TypeCAA:   3600,
+}
+
+// defaultTTLRecord is the default key of the ENS text record that overrides
+// the TTLs of the records synthesized for a domain.
+const defaultTTLRecord = "dns.ttl"
+
+// maxTTL is the maximum TTL of a record (RFC 2181).
+const maxTTL = 1<<31 - 1
+
+// ttlCacheTTL is the time for which the TTL text record of a domain is
+// cached.
+const ttlCacheTTL = time.Minute
+
+// ttlCache holds the TTL text records of domains, keyed by record key and
+// domain.
+var ttlCache *lru.Cache
+
+func init() {
+	ttlCache, _ = lru.NewWithEvict(1024, func(key interface{}, value interface{}) {
+		cacheEvictions.WithLabelValues("ttl").Inc()
+	})
+}
+
+type ttlOverrideKey struct{}
+
+// ttlOverride is the TTL text record of a domain.  It is read once for each
+// query and used for all of the records synthesized to answer it.
+type ttlOverride struct {
+	domain string
+	ttl    uint32
+	exists bool
+}
+
+// ttlCacheEntry is a cached TTL text record of a domain.
+type ttlCacheEntry struct {
+	override *ttlOverride
+	fetched  time.Time
+}
+
+// withTTLOverride returns a context that carries the TTL text record of the
+// given domain, if TTL text records are enabled.
+func (e ENS) withTTLOverride(ctx context.Context, domain string) context.Context {
+	if e.TTLRecord == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, ttlOverrideKey{}, e.ttlOverride(ctx, domain))
+}
+
+// ttlOverrideFrom returns the TTL text record carried by a context, if any.
+func ttlOverrideFrom(ctx context.Context) *ttlOverride {
+	override, _ := ctx.Value(ttlOverrideKey{}).(*ttlOverride)
+	return override
+}
+
+// ttl returns the TTL of synthesized records of the given type for a domain.
+// The domain's TTL text record takes precedence over the configured TTLs.
+func (e ENS) ttl(ctx context.Context, domain string, rrtype uint16) uint32 {
+	if e.TTLRecord != "" {
+		override := ttlOverrideFrom(ctx)
+		if override == nil || override.domain != domain {
+			override = e.ttlOverride(ctx, domain)
+		}
+		if override.exists {
+			return override.ttl
+		}
+	}
+	return e.configuredTTL(rrtype)
+}
+
+// configuredTTL returns the configured TTL of synthesized records of the given
+// type, ignoring TTL text records.
+func (e ENS) configuredTTL(rrtype uint16) uint32 {
+	if ttl, exists := e.TTLs[rrtype]; exists {
+		return ttl
+	}
+	return defaultTTLs[rrtype]
+}
+
+// ttlOverride obtains the TTL text record of a domain, from the cache if
+// possible.
+func (e ENS) ttlOverride(ctx context.Context, domain string) *ttlOverride {
+	key := e.TTLRecord + " " + domain
+	if cached, exists := ttlCache.Get(key); exists {
+		if entry := cached.(*ttlCacheEntry); time.Since(entry.fetched) < ttlCacheTTL {
+			cacheHits.WithLabelValues("ttl").Inc()
+			return entry.override
+		}
+	}
+	cacheMisses.WithLabelValues("ttl").Inc()
+
+	override, err := e.fetchTTLOverride(ctx, domain)
+	if err != nil {
+		// Failures are not cached, so the record is read again by the next
+		// query.
+		return override
+	}
+	ttlCache.Add(key, &ttlCacheEntry{override: override, fetched: time.Now()})
+	return override
+}
+
+// fetchTTLOverride obtains the TTL text record of a domain from ENS.
+func (e ENS) fetchTTLOverride(ctx context.Context, domain string) (*ttlOverride, error) {
+	override := &ttlOverride{domain: domain}
+	ethDomain := strings.TrimSuffix(domain, ".")
+	info, err := e.resolverInfo(ctx, ethDomain)
+	if err == errNoResolver {
+		return override, nil
+	}
+	if err != nil {
+		return override, err
+	}
+	if !info.capabilities.supports(capText) {
+		return override, nil
+	}
+	value, err := text(ctx, info.resolver, ethDomain, e.TTLRecord)
+	if err != nil {
+		if isRevert(err) {
+			// The resolver does not hold the record.
+			return override, nil
+		}
+		return override, err
+	}
+	if value == "" {
+		return override, nil
+	}
+	ttl, err := parseTTL(value)
+	if err != nil {
+		log.Debugf("invalid %s text record for %s: %v", e.TTLRecord, ethDomain, err)
+		return override, nil
+	}
+	override.ttl = ttl
+	override.exists = true
+	return override, nil
+}
+
+// parseTTL parses a TTL in seconds.
+func parseTTL(input string) (uint32, error) {
+	ttl, err := strconv.ParseUint(strings.TrimSpace(input), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if ttl > maxTTL {
+		return 0, strconv.ErrRange
+	}
+	return uint32(ttl), nil
+}
@@ -0,0 +1,92 @@
+package ens
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/miekg/dns"
+	ens "github.com/wealdtech/go-ens/v3"
+)
+
+func TestTTL(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttls      map[uint16]uint32
+		ttlRecord string
+		text      string
+		rrtype    uint16
+		ttl       uint32
+	}{
+		{name: "Default", rrtype: dns.TypeA, ttl: 3600},
+		{name: "DefaultSOA", rrtype: dns.TypeSOA, ttl: 10800},
+		{name: "Configured", ttls: map[uint16]uint32{dns.TypeA: 60}, rrtype: dns.TypeA, ttl: 60},
+		{name: "OtherConfigured", ttls: map[uint16]uint32{dns.TypeA: 60}, rrtype: dns.TypeAAAA, ttl: 3600},
+		{name: "Override", ttls: map[uint16]uint32{dns.TypeA: 60}, ttlRecord: "dns.ttl", text: "30", rrtype: dns.TypeA, ttl: 30},
+		{name: "OverrideSOA", ttlRecord: "dns.ttl", text: "30", rrtype: dns.TypeSOA, ttl: 30},
+		{name: "OverrideNotEnabled", text: "30", rrtype: dns.TypeA, ttl: 3600},
+		{name: "OverrideMissing", ttls: map[uint16]uint32{dns.TypeA: 60}, ttlRecord: "dns.ttl", rrtype: dns.TypeA, ttl: 60},
+		{name: "OverrideInvalid", ttlRecord: "dns.ttl", text: "soon", rrtype: dns.TypeA, ttl: 3600},
+		{name: "OverrideTooLarge", ttlRecord: "dns.ttl", text: "4294967295", rrtype: dns.TypeA, ttl: 3600},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Resolver information is cached along with the backend, so each
+			// test needs its own domain and resolver.
+			resolver := common.BigToAddress(big.NewInt(int64(0x7710 + i)))
+			backend := &methodBackend{results: map[string][]byte{
+				"resolver(bytes32)":         abiWords(resolver),
+				"supportsInterface(bytes4)": abiWords(1),
+				"text(bytes32,string)":      abiBytes([]byte(test.text)),
+			}}
+			registry, err := ens.NewRegistry(backend)
+			if err != nil {
+				t.Fatalf("Failed to create registry: %v", err)
+			}
+			e := ENS{Backend: backend, Registry: registry, TTLs: test.ttls, TTLRecord: test.ttlRecord}
+			if ttl := e.ttl(context.Background(), fmt.Sprintf("ttl%d.test.eth.", i), test.rrtype); ttl != test.ttl {
+				t.Fatalf("Unexpected TTL %d (expected %d)", ttl, test.ttl)
+			}
+		})
+	}
+}
+
+func TestTTLOverrideReadOnce(t *testing.T) {
+	resolver := common.HexToAddress("0x4aC7e8E6d5dD4C7e5D9f2C6dBf5C7f8D4e3B2a1C")
+	backend := &methodBackend{
+		results: map[string][]byte{
+			"resolver(bytes32)":         abiWords(resolver),
+			"supportsInterface(bytes4)": abiWords(1),
+			"text(bytes32,string)":      abiBytes([]byte("30")),
+		},
+		calls: make(map[string]int),
+	}
+	registry, err := ens.NewRegistry(backend)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	e := ENS{Backend: backend, Registry: registry, TTLRecord: "dns.ttl"}
+
+	// The record is read once for the records synthesized for a query.
+	ctx := e.withTTLOverride(context.Background(), "ttlonce.test.eth.")
+	for _, rrtype := range []uint16{dns.TypeSOA, dns.TypeNS, dns.TypeTXT, dns.TypeA, dns.TypeAAAA} {
+		if ttl := e.ttl(ctx, "ttlonce.test.eth.", rrtype); ttl != 30 {
+			t.Fatalf("Unexpected TTL %d for type %d", ttl, rrtype)
+		}
+	}
+	if calls := backend.calls["text(bytes32,string)"]; calls != 1 {
+		t.Fatalf("Unexpected %d reads of the TTL record", calls)
+	}
+
+	// The record is cached for later queries.
+	ctx = e.withTTLOverride(context.Background(), "ttlonce.test.eth.")
+	if ttl := e.ttl(ctx, "ttlonce.test.eth.", dns.TypeA); ttl != 30 {
+		t.Fatalf("Unexpected TTL %d", ttl)
+	}
+	if calls := backend.calls["text(bytes32,string)"]; calls != 1 {
+		t.Fatalf("Unexpected %d reads of the TTL record", calls)
+	}
+}
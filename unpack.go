@@ -0,0 +1,45 @@
+package ens
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// maxRecordDataSize is the maximum size of the on-chain data for an RRset,
+	// the largest that could be returned in a DNS message.
+	maxRecordDataSize = dns.MaxMsgSize
+	// maxRecordCount is the maximum number of records in an on-chain RRset.
+	maxRecordCount = 64
+)
+
+// unpackRRs unpacks the wire-format records held on-chain for an RRset.  If
+// the data is invalid the records unpacked before the problem was found are
+// returned along with an error.
+func unpackRRs(data []byte) ([]dns.RR, error) {
+	if len(data) > maxRecordDataSize {
+		return nil, fmt.Errorf("record data of %d bytes exceeds limit of %d bytes", len(data), maxRecordDataSize)
+	}
+
+	results := make([]dns.RR, 0)
+	offset := 0
+	for offset < len(data) {
+		if len(results) == maxRecordCount {
+			return results, fmt.Errorf("record data exceeds limit of %d records", maxRecordCount)
+		}
+		rr, next, err := dns.UnpackRR(data, offset)
+		if err != nil {
+			return results, fmt.Errorf("invalid record at offset %d: %v", offset, err)
+		}
+		if next <= offset {
+			return results, fmt.Errorf("invalid record at offset %d: no data consumed", offset)
+		}
+		if rr == nil {
+			return results, fmt.Errorf("invalid record at offset %d: no record", offset)
+		}
+		results = append(results, rr)
+		offset = next
+	}
+	return results, nil
+}
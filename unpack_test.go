@@ -0,0 +1,114 @@
+package ens
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// packRRs packs records in to their on-chain wire format.
+func packRRs(t testing.TB, rrs ...string) []byte {
+	var buf bytes.Buffer
+	for _, rr := range rrs {
+		record, err := dns.NewRR(rr)
+		if err != nil {
+			t.Fatalf("Failed to create RR: %v", err)
+		}
+		data := make([]byte, dns.Len(record))
+		n, err := dns.PackRR(record, data, 0, nil, false)
+		if err != nil {
+			t.Fatalf("Failed to pack RR: %v", err)
+		}
+		buf.Write(data[:n])
+	}
+	return buf.Bytes()
+}
+
+func TestUnpackRRs(t *testing.T) {
+	valid := packRRs(t, "www.wealdtech.eth. 3600 IN A 1.2.3.4", "www.wealdtech.eth. 3600 IN A 1.2.3.5")
+	many := make([]string, maxRecordCount+1)
+	for i := range many {
+		many[i] = "www.wealdtech.eth. 3600 IN A 1.2.3.4"
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		records int
+		err     string
+	}{
+		{
+			name: "Empty",
+			data: []byte{},
+		},
+		{
+			name:    "Valid",
+			data:    valid,
+			records: 2,
+		},
+		{
+			name:    "Truncated",
+			data:    valid[:len(valid)-1],
+			records: 1,
+			err:     "invalid record at offset 33: ",
+		},
+		{
+			name:    "TrailingGarbage",
+			data:    append(append([]byte{}, valid...), 0xff),
+			records: 2,
+			err:     "invalid record at offset 66: ",
+		},
+		{
+			name:    "TooManyRecords",
+			data:    packRRs(t, many...),
+			records: maxRecordCount,
+			err:     "record data exceeds limit of 64 records",
+		},
+		{
+			name: "TooLarge",
+			data: make([]byte, maxRecordDataSize+1),
+			err:  "record data of 65536 bytes exceeds limit of 65535 bytes",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rrs, err := unpackRRs(test.data)
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			} else {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			if len(rrs) != test.records {
+				t.Fatalf("Unexpected number of records %d (expected %d)", len(rrs), test.records)
+			}
+		})
+	}
+}
+
+func FuzzUnpackRRs(f *testing.F) {
+	f.Add(packRRs(f, "www.wealdtech.eth. 3600 IN A 1.2.3.4"))
+	f.Add(packRRs(f, "www.wealdtech.eth. 3600 IN AAAA 2001:db8::1", "www.wealdtech.eth. 3600 IN AAAA 2001:db8::2"))
+	f.Add(packRRs(f, `www.wealdtech.eth. 3600 IN TXT "a=b" "c=d"`))
+	f.Add(packRRs(f, "www.wealdtech.eth. 3600 IN CNAME wealdtech.eth."))
+	f.Add([]byte{0xc0, 0x00})
+	f.Add([]byte{0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		rrs, _ := unpackRRs(data)
+		if len(rrs) > maxRecordCount {
+			t.Fatalf("Too many records returned: %d", len(rrs))
+		}
+		for _, rr := range inBailiwick(rrs, "www.wealdtech.eth.", dns.TypeA) {
+			if rr.Header().Rrtype != dns.TypeA {
+				t.Fatalf("Unexpected record %v", rr)
+			}
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package ens
 
 import (
+	"context"
 	"strings"
 
 	"github.com/miekg/dns"
@@ -8,12 +9,12 @@ import (
 
 // eligibleForWildcard sees if a name is eligible for a wildcard.  To be so it
 // must have no resource records of any type specifically against its name
-func eligibleForWildcard(server Server, domain string, name string) bool {
+func eligibleForWildcard(ctx context.Context, server Server, domain string, name string) bool {
 	if strings.HasPrefix(domain, "*.") {
 		// Already a wildcard
 		return false
 	}
-	hasRecords, err := server.HasRecords(domain, name)
+	hasRecords, err := server.HasRecords(ctx, domain, name)
 	if err != nil {
 		// TODO now what?
 		return false